@@ -0,0 +1,180 @@
+// Package actor is the Server pattern from the lesson (a goroutine, a mailbox
+// channel and a select loop) with a safety net: typed messages, request/reply
+// and supervisors that restart an actor when it panics
+package actor
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Behavior handles one message at a time, so the state it closes over
+// needs no mutex. The reply goes back to Ask, Tell ignores it.
+type Behavior[M, R any] func(ctx context.Context, msg M) (R, error)
+
+// ErrStopped is returned when the actor doesn't take messages anymore
+var ErrStopped = errors.New("actor: stopped")
+
+// Ref is how you talk to an actor, it stays valid across restarts
+// and messages waiting in the mailbox are kept when the actor restarts
+type Ref[M, R any] struct {
+	name        string
+	newBehavior func() Behavior[M, R] // called on every (re)start, so the state starts fresh
+	mailbox     chan envelope[M, R]
+	stopped     chan struct{} // closed on shutdown, after that nobody reads the mailbox
+
+	// the running incarnation, only the supervisor loop touches these
+	gen    int
+	quit   chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	supervisor *Supervisor
+}
+
+type envelope[M, R any] struct {
+	ctx   context.Context // the context of Ask, nil for Tell
+	msg   M
+	reply chan result[R] // nil for Tell
+}
+
+type result[R any] struct {
+	val R
+	err error
+}
+
+// Name is the name given to Spawn
+func (r *Ref[M, R]) Name() string {
+	return r.name
+}
+
+// Tell puts the message into the mailbox and doesn't wait for the reply,
+// it blocks only while the mailbox is full
+func (r *Ref[M, R]) Tell(ctx context.Context, msg M) error {
+	select {
+	case <-r.stopped:
+		return ErrStopped
+	default:
+	}
+
+	select {
+	case r.mailbox <- envelope[M, R]{msg: msg}:
+		return nil
+	case <-r.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ask sends the message and waits for the reply, use a ctx with a timeout
+// if the actor is slow the message is dropped when ctx is done before the actor gets to it
+func (r *Ref[M, R]) Ask(ctx context.Context, msg M) (R, error) {
+	var zero R
+
+	select {
+	case <-r.stopped:
+		return zero, ErrStopped
+	default:
+	}
+
+	reply := make(chan result[R], 1) // the actor never blocks on a caller that left
+	select {
+	case r.mailbox <- envelope[M, R]{ctx: ctx, msg: msg, reply: reply}:
+	case <-r.stopped:
+		return zero, ErrStopped
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+
+	select {
+	case res := <-reply:
+		return res.val, res.err
+	case <-r.stopped:
+		return zero, ErrStopped
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// start runs a new incarnation with a fresh behavior
+func (r *Ref[M, R]) start() {
+	if r.cancel != nil {
+		r.cancel() // the old incarnation is gone, free its context
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.gen++
+	r.quit = make(chan struct{})
+	r.done = make(chan struct{})
+	r.cancel = cancel
+
+	go r.loop(ctx, r.gen, r.newBehavior(), r.quit, r.done)
+}
+
+// stop asks the running incarnation to finish and waits for it
+func (r *Ref[M, R]) stop(ctx context.Context) error {
+	if r.quit == nil {
+		return nil
+	}
+	close(r.quit)
+	r.cancel() // a long message sees ctx.Done()
+
+	select {
+	case <-r.done:
+		r.quit = nil
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("actor %q: %w", r.name, ctx.Err())
+	}
+}
+
+func (r *Ref[M, R]) shutdown() {
+	close(r.stopped)
+}
+
+func (r *Ref[M, R]) generation() int {
+	return r.gen
+}
+
+func (r *Ref[M, R]) loop(ctx context.Context, gen int, behavior Behavior[M, R], quit, done chan struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-quit:
+			return
+
+		case env := <-r.mailbox:
+			if env.ctx != nil && env.ctx.Err() != nil {
+				continue // the caller is not waiting anymore
+			}
+
+			val, err, panicked := r.handle(ctx, behavior, env.msg)
+			if env.reply != nil {
+				env.reply <- result[R]{val: val, err: err}
+			}
+			if panicked {
+				// the state may be broken, let the supervisor decide what to do
+				r.supervisor.failed(r, gen, err, quit)
+				return
+			}
+		}
+	}
+}
+
+func (r *Ref[M, R]) handle(ctx context.Context, behavior Behavior[M, R], msg M) (val R, err error, panicked bool) {
+	err = panics.Call(func() error {
+		var err error
+		val, err = behavior(ctx, msg)
+		return err
+	})
+
+	var p *panics.PanicError
+	if errors.As(err, &p) {
+		return val, fmt.Errorf("actor %q: %w", r.name, err), true
+	}
+	return val, err, false
+}
@@ -0,0 +1,229 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Strategy says which actors are restarted when one of them panics
+type Strategy int
+
+const (
+	OneForOne Strategy = iota // only the actor that panicked
+	OneForAll                 // all actors of the supervisor, for actors that depend on each other
+)
+
+// ErrTooManyRestarts is the reason a supervisor gives up
+var ErrTooManyRestarts = errors.New("actor: too many restarts")
+
+// Supervisor owns actors and restarts them when they panic
+//
+// If there are more than MaxRestarts restarts within Within the problem is
+// not going away, the supervisor stops all actors and Err returns
+// ErrTooManyRestarts. MaxRestarts 0 means never restart: the first panic
+// stops everything.
+type Supervisor struct {
+	Strategy    Strategy
+	MaxRestarts int           // < 0 means the default, 3
+	Within      time.Duration // 0 means 5 seconds
+
+	// OnRestart is called before an actor is restarted, it's optional
+	OnRestart func(actor string, reason error)
+
+	once     sync.Once
+	mu       sync.Mutex
+	children []child // in the order of Spawn
+	closed   bool    // no more Spawn after shutdown started
+	restarts []time.Time
+
+	failures chan failure
+	stopReq  chan context.Context
+	stopErr  chan error
+	done     chan struct{} // closed when all actors are stopped
+	err      error
+}
+
+// child is a *Ref of any message type
+type child interface {
+	Name() string
+	start()
+	stop(ctx context.Context) error
+	shutdown()
+	generation() int
+}
+
+type failure struct {
+	child  child
+	gen    int
+	reason error
+}
+
+// Spawn starts an actor under the supervisor, newBehavior is called on every
+// (re)start and mailbox is the size of the mailbox channel
+func Spawn[M, R any](s *Supervisor, name string, mailbox int, newBehavior func() Behavior[M, R]) (*Ref[M, R], error) {
+	s.init()
+
+	ref := &Ref[M, R]{
+		name:        name,
+		newBehavior: newBehavior,
+		mailbox:     make(chan envelope[M, R], mailbox),
+		stopped:     make(chan struct{}),
+		supervisor:  s,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStopped
+	}
+
+	s.children = append(s.children, ref)
+	ref.start()
+	return ref, nil
+}
+
+// Stop shuts the actors down in the reverse order of Spawn, so an actor
+// never outlives the actors spawned after it (they may depend on it)
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.init()
+
+	select {
+	case s.stopReq <- ctx:
+	case <-s.done:
+		return s.Err() // it already gave up or was stopped
+	}
+
+	select {
+	case err := <-s.stopErr:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the supervisor stopped all its actors
+func (s *Supervisor) Done() <-chan struct{} {
+	s.init()
+	return s.done
+}
+
+// Err says why the supervisor stopped, nil while it runs or after Stop
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Supervisor) init() {
+	s.once.Do(func() {
+		s.failures = make(chan failure)
+		s.stopReq = make(chan context.Context)
+		s.stopErr = make(chan error, 1)
+		s.done = make(chan struct{})
+		go s.loop()
+	})
+}
+
+// failed is called by a panicking actor, quit is closed when the supervisor
+// is stopping this actor anyway (OneForAll restart or shutdown)
+func (s *Supervisor) failed(c child, gen int, reason error, quit <-chan struct{}) {
+	select {
+	case s.failures <- failure{child: c, gen: gen, reason: reason}:
+	case <-quit:
+	case <-s.done:
+	}
+}
+
+// loop is the only place where actors are restarted and stopped
+func (s *Supervisor) loop() {
+	for {
+		select {
+		case f := <-s.failures:
+			if f.child.generation() != f.gen {
+				continue // already restarted by OneForAll
+			}
+
+			if !s.allowRestart() {
+				err := fmt.Errorf("%w: %s: %v", ErrTooManyRestarts, f.child.Name(), f.reason)
+				s.shutdown(context.Background())
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+				close(s.done)
+				return
+			}
+
+			if s.OnRestart != nil {
+				s.OnRestart(f.child.Name(), f.reason)
+			}
+			s.restart(f.child)
+
+		case ctx := <-s.stopReq:
+			s.stopErr <- s.shutdown(ctx)
+			close(s.done)
+			return
+		}
+	}
+}
+
+func (s *Supervisor) restart(failed child) {
+	if s.Strategy == OneForOne {
+		failed.start() // its goroutine already returned
+		return
+	}
+
+	s.mu.Lock()
+	children := append([]child(nil), s.children...)
+	s.mu.Unlock()
+
+	// stop the others in reverse order and start everything again in order
+	for i := len(children) - 1; i >= 0; i-- {
+		if children[i] != failed {
+			children[i].stop(context.Background())
+		}
+	}
+	for _, c := range children {
+		c.start()
+	}
+}
+
+func (s *Supervisor) allowRestart() bool {
+	limit, within := s.MaxRestarts, s.Within
+	if limit < 0 {
+		limit = 3
+	}
+	if within <= 0 {
+		within = 5 * time.Second
+	}
+
+	now := time.Now()
+	recent := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < within {
+			recent = append(recent, t)
+		}
+	}
+	s.restarts = append(recent, now)
+
+	return len(s.restarts) <= limit
+}
+
+func (s *Supervisor) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	children := append([]child(nil), s.children...)
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(children) - 1; i >= 0; i-- {
+		if err := children[i].stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		children[i].shutdown()
+	}
+	return errors.Join(errs...)
+}
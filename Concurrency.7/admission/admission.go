@@ -0,0 +1,300 @@
+// Package admission decides if a client may start one more request
+//
+// Every client has a token bucket (how many requests per second, with bursts)
+// and a limit of requests in flight. A client is its API key when the key is
+// known, otherwise its IP address: an unknown key is not trusted, or a client
+// could get a fresh quota with every made-up key.
+//
+// Behind a reverse proxy (like balancer) every connection comes from the
+// proxy, all clients would share its IP and one quota. List the proxy in
+// Config.TrustedProxies and the IP comes from X-Forwarded-For instead.
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyHeader carries the API key of the client
+const KeyHeader = "X-API-Key"
+
+// Quota is what one client of a tier may do
+type Quota struct {
+	Rate        float64 `json:"rate"`          // requests per second, the bucket refills at this speed
+	Burst       int     `json:"burst"`         // size of the bucket, requests allowed at once after a pause
+	MaxInFlight int     `json:"max_in_flight"` // requests running at the same time
+}
+
+// Config maps clients to tiers and tiers to quotas, clients that are not
+// in Clients get DefaultTier
+type Config struct {
+	DefaultTier string            `json:"default_tier"`
+	Tiers       map[string]Quota  `json:"tiers"`
+	Clients     map[string]string `json:"clients"` // API key -> tier
+
+	// TrustedProxies are addresses (10.0.0.5) or networks (10.0.0.0/8) of
+	// the proxies in front of us. Only they may name the client in
+	// X-Forwarded-For, anybody else could put any IP there.
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// Validate checks that every tier a client can get exists and makes sense
+func (c *Config) Validate() error {
+	var errs []error
+	if _, ok := c.Tiers[c.DefaultTier]; !ok {
+		errs = append(errs, fmt.Errorf("default tier %q is not in tiers", c.DefaultTier))
+	}
+	for name, q := range c.Tiers {
+		if q.Rate <= 0 || q.Burst < 1 || q.MaxInFlight < 1 {
+			errs = append(errs, fmt.Errorf("tier %q: rate, burst and max_in_flight must be positive", name))
+		}
+	}
+	for key, tier := range c.Clients {
+		if _, ok := c.Tiers[tier]; !ok {
+			errs = append(errs, fmt.Errorf("client %q: unknown tier %q", key, tier))
+		}
+	}
+	for _, p := range c.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			errs = append(errs, fmt.Errorf("trusted proxy %q: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Decision is the answer for one request
+type Decision struct {
+	Allowed   bool
+	Reason    string        // why not
+	Limit     int           // the burst of the tier
+	Remaining int           // whole tokens left in the bucket
+	Reset     time.Duration // until the bucket is full again
+	Retry     time.Duration // until the next request may pass, only when not allowed
+}
+
+// Limiter keeps the state of every client, create it with New
+type Limiter struct {
+	config func() *Config // called on every request, so a reload is used at once
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	tokens   float64
+	last     time.Time // when tokens was computed
+	inFlight int
+}
+
+// idle clients with a full bucket are forgotten after this
+const idleTTL = 10 * time.Minute
+
+// New creates a limiter, config returns the current quotas (it may change
+// between calls, hotconfig.ConfigStore.Load is a good fit)
+func New(config func() *Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// Identify returns the key of the client and its quota
+func (l *Limiter) Identify(r *http.Request) (string, Quota) {
+	cfg := l.config()
+
+	if key := r.Header.Get(KeyHeader); key != "" {
+		if tier, ok := cfg.Clients[key]; ok {
+			return "key:" + key, cfg.Tiers[tier]
+		}
+	}
+
+	return "ip:" + cfg.clientIP(r), cfg.Tiers[cfg.DefaultTier]
+}
+
+// clientIP is the address of the connection. When that is a trusted proxy
+// it's the last address in X-Forwarded-For that is not one: every proxy
+// appends where it got the request from, what is left of that came from
+// the client and may be made up.
+func (c *Config) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !c.trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !c.trusted(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip // only proxies, the first one is as close to the client as we get
+}
+
+func (c *Config) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, p := range c.TrustedProxies {
+		if prefix, err := parsePrefix(p); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix reads a network or a single address (a /32 or /128 network)
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Admit takes a token and an in-flight slot of the client, release must be
+// called when the request is done (it's nil when the request is rejected)
+func (l *Limiter) Admit(key string, q Quota) (release func(), d Decision) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{tokens: float64(q.Burst), last: now}
+		l.clients[key] = c
+	}
+
+	// refill, the quota may have changed since the last request
+	c.tokens = math.Min(float64(q.Burst), c.tokens+now.Sub(c.last).Seconds()*q.Rate)
+	c.last = now
+
+	d.Limit = q.Burst
+
+	switch {
+	case c.inFlight >= q.MaxInFlight:
+		d.Reason = "too many requests in flight"
+		d.Retry = time.Second // we can't know when one of them ends
+	case c.tokens < 1:
+		d.Reason = "rate limit exceeded"
+		d.Retry = seconds((1 - c.tokens) / q.Rate)
+	default:
+		d.Allowed = true
+		c.tokens--
+		c.inFlight++
+	}
+
+	d.Remaining = int(c.tokens)
+	d.Reset = seconds((float64(q.Burst) - c.tokens) / q.Rate)
+
+	if !d.Allowed {
+		return nil, d
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.mu.Lock()
+			c.inFlight--
+			l.mu.Unlock()
+		})
+	}
+	return release, d
+}
+
+// sweep forgets clients that would start with a full bucket anyway, l.mu is held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, c := range l.clients {
+		if c.inFlight == 0 && now.Sub(c.last) > idleTTL {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Middleware rejects requests over the quota with 429 Too Many Requests,
+// every response gets the RateLimit-* headers so clients can slow down before
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, q := l.Identify(r)
+		release, d := l.Admit(key, q)
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", q.Burst, ceilSeconds(seconds(float64(q.Burst)/q.Rate))))
+
+		if !d.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(d.Retry)))
+			http.Error(w, d.Reason, http.StatusTooManyRequests)
+			return
+		}
+
+		held := &hold{release: release}
+		defer func() {
+			if !held.held {
+				release() // the handler didn't take it over with Hold
+			}
+		}()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), holdKey{}, held)))
+	})
+}
+
+type holdKey struct{}
+
+type hold struct {
+	release func()
+	held    bool
+}
+
+// Hold keeps the in-flight slot of the request when the handler returns,
+// for work that goes on in the background. The handler calls it before it
+// returns and calls release when the work is done. Without Middleware in
+// front release does nothing.
+func Hold(r *http.Request) (release func()) {
+	h, ok := r.Context().Value(holdKey{}).(*hold)
+	if !ok {
+		return func() {}
+	}
+	h.held = true
+	return h.release
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds rounds up, "0" would tell the client it may retry right now
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,307 @@
+// Package balancer is a reverse proxy in front of several copies of a server
+//
+// Every request goes to one healthy backend chosen by a Strategy. Health
+// checks run in the background and take a backend out of the rotation
+// after a few failures and back in after a few successes. A request that
+// failed before the backend answered is tried on another backend, but only
+// when it's safe to send it twice (GET, HEAD, PUT, DELETE... without a body).
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is one server behind the proxy
+type Backend struct {
+	URL *url.URL
+
+	proxy       *httputil.ReverseProxy
+	healthy     atomic.Bool
+	outstanding atomic.Int64
+
+	mu         sync.Mutex // the counters of the health checks
+	fails, oks int
+}
+
+// Healthy reports whether the backend gets requests
+func (b *Backend) Healthy() bool { return b.healthy.Load() }
+
+// Outstanding is the number of requests in progress
+func (b *Backend) Outstanding() int64 { return b.outstanding.Load() }
+
+// Proxy balances requests over its backends, create it with New
+//
+// The zero values of the fields are usable: health checks every 5 seconds
+// on /healthz, 2 failures eject and 2 successes reinstate a backend,
+// 2 retries.
+type Proxy struct {
+	Strategy Strategy
+
+	HealthPath     string
+	HealthInterval time.Duration
+	HealthTimeout  time.Duration
+	Unhealthy      int // failed checks in a row that eject a backend
+	Healthy        int // good checks in a row that bring it back
+	Retries        int // extra attempts for idempotent requests, < 0 means none
+
+	// OnChange is called when a backend is ejected or reinstated, it's optional
+	OnChange func(b *Backend, healthy bool)
+
+	Transport http.RoundTripper // nil means http.DefaultTransport
+
+	backends []*Backend
+}
+
+// New creates a proxy for the backend urls, all of them start healthy
+func New(strategy Strategy, targets ...string) (*Proxy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("balancer: no backends")
+	}
+
+	p := &Proxy{Strategy: strategy}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("balancer: backend %q: %w", target, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("balancer: backend %q: need scheme and host", target)
+		}
+
+		b := &Backend{URL: u}
+		b.healthy.Store(true)
+		b.proxy = &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(u)
+				pr.SetXForwarded()
+			},
+			Transport: transport{p},
+			// errors are handled in ServeHTTP, see attemptWriter
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				w.(*attemptWriter).err = err
+			},
+		}
+		p.backends = append(p.backends, b)
+	}
+	return p, nil
+}
+
+// Backends returns the backends in the order of New
+func (p *Proxy) Backends() []*Backend {
+	return slices.Clone(p.backends)
+}
+
+// transport reads p.Transport on every request, so it can be set after New
+type transport struct{ p *Proxy }
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.p.Transport != nil {
+		return t.p.Transport.RoundTrip(r)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	retries := p.Retries
+	switch {
+	case !idempotent(r), retries < 0:
+		retries = 0
+	case retries == 0:
+		retries = 2
+	}
+
+	var tried []*Backend
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		b := p.pick(r, tried)
+		if b == nil {
+			break
+		}
+		tried = append(tried, b)
+
+		aw := &attemptWriter{ResponseWriter: w}
+		b.serve(aw, r)
+
+		if aw.err == nil {
+			return
+		}
+		if r.Context().Err() != nil {
+			return // the client is gone, that's not the fault of the backend
+		}
+		lastErr = aw.err
+		p.report(b, false) // a passive check: it didn't answer
+
+		if aw.wrote {
+			return // the answer is half sent, nothing to retry
+		}
+	}
+
+	if lastErr == nil {
+		http.Error(w, "no healthy backend", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "bad gateway", http.StatusBadGateway)
+}
+
+// serve counts the request as outstanding while the backend has it, the
+// ReverseProxy panics with http.ErrAbortHandler when the client goes away
+// in the middle of a response
+func (b *Backend) serve(w *attemptWriter, r *http.Request) {
+	b.outstanding.Add(1)
+	defer b.outstanding.Add(-1)
+	b.proxy.ServeHTTP(w, r)
+}
+
+// pick asks the strategy for a healthy backend that was not tried yet
+func (p *Proxy) pick(r *http.Request, tried []*Backend) *Backend {
+	var candidates []*Backend
+	for _, b := range p.backends {
+		if b.Healthy() && !slices.Contains(tried, b) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	s := p.Strategy
+	if s == nil {
+		s = LeastOutstanding{}
+	}
+	return s.Pick(candidates, r)
+}
+
+// idempotent requests may be sent twice, a body can't be read twice
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody
+}
+
+// attemptWriter remembers if the ReverseProxy failed and whether anything
+// reached the client before, only then a retry is safe
+type attemptWriter struct {
+	http.ResponseWriter
+	wrote bool
+	err   error
+}
+
+func (w *attemptWriter) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *attemptWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush of the real writer,
+// so event streams (GET /tasks/{id}/events) work through the proxy
+func (w *attemptWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush is what ReverseProxy calls for streaming responses
+func (w *attemptWriter) Flush() {
+	w.wrote = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// RunHealthChecks checks every backend every interval until ctx is done
+func (p *Proxy) RunHealthChecks(ctx context.Context) {
+	interval := p.HealthInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, b := range p.backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.report(b, p.check(ctx, b))
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) check(ctx context.Context, b *Backend) bool {
+	path, timeout := p.HealthPath, p.HealthTimeout
+	if path == "" {
+		path = "/healthz"
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL.JoinPath(path).String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := transport{p}.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// report counts a result in a row and moves the backend in or out
+func (p *Proxy) report(b *Backend, ok bool) {
+	unhealthy, healthy := p.Unhealthy, p.Healthy
+	if unhealthy <= 0 {
+		unhealthy = 2
+	}
+	if healthy <= 0 {
+		healthy = 2
+	}
+
+	b.mu.Lock()
+	if ok {
+		b.oks++
+		b.fails = 0
+	} else {
+		b.fails++
+		b.oks = 0
+	}
+	var changed bool
+	switch {
+	case ok && !b.Healthy() && b.oks >= healthy:
+		b.healthy.Store(true)
+		changed = true
+	case !ok && b.Healthy() && b.fails >= unhealthy:
+		b.healthy.Store(false)
+		changed = true
+	}
+	b.mu.Unlock()
+
+	if changed && p.OnChange != nil {
+		p.OnChange(b, ok)
+	}
+}
@@ -0,0 +1,259 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backend answers with its name, /healthz fails while down is set
+type backend struct {
+	name string
+	srv  *httptest.Server
+	down atomic.Bool
+}
+
+func startBackends(t *testing.T, names ...string) []*backend {
+	t.Helper()
+	var backends []*backend
+	for _, name := range names {
+		b := &backend{name: name}
+		b.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" && b.down.Load() {
+				http.Error(w, "down", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, b.name)
+		}))
+		t.Cleanup(b.srv.Close)
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+func newProxy(t *testing.T, s Strategy, backends []*backend) *Proxy {
+	t.Helper()
+	var urls []string
+	for _, b := range backends {
+		urls = append(urls, b.srv.URL)
+	}
+	p, err := New(s, urls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// get sends one request through the proxy and returns the name of the backend
+func get(t *testing.T, p *Proxy, r *http.Request) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: %d %s", r.URL, w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	return w.Body.String()
+}
+
+func TestRoundRobin(t *testing.T) {
+	p := newProxy(t, &RoundRobin{}, startBackends(t, "a", "b", "c"))
+
+	var got []string
+	for range 6 {
+		got = append(got, get(t, p, httptest.NewRequest("GET", "/", nil)))
+	}
+	if s := strings.Join(got, ""); s != "abcabc" {
+		t.Fatalf("order %q, want abcabc", s)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	backends := startBackends(t, "a", "b", "c")
+	p := newProxy(t, ConsistentHash{Key: func(r *http.Request) string {
+		return r.URL.Query().Get("task")
+	}}, backends)
+
+	keys := []string{"build", "test", "deploy", "lint", "bench", "docs", "release", "fmt"}
+	first := map[string]string{}
+	for _, key := range keys {
+		first[key] = get(t, p, httptest.NewRequest("GET", "/?task="+key, nil))
+	}
+	for range 3 {
+		for _, key := range keys {
+			if got := get(t, p, httptest.NewRequest("GET", "/?task="+key, nil)); got != first[key] {
+				t.Fatalf("key %q went to %s, before to %s", key, got, first[key])
+			}
+		}
+	}
+
+	// without b only the keys of b move
+	p.backends[1].healthy.Store(false)
+	for _, key := range keys {
+		got := get(t, p, httptest.NewRequest("GET", "/?task="+key, nil))
+		if first[key] != "b" && got != first[key] {
+			t.Errorf("key %q moved from %s to %s, but %s is still up", key, first[key], got, first[key])
+		}
+	}
+}
+
+func TestConsistentHashWithoutKey(t *testing.T) {
+	p := newProxy(t, ConsistentHash{}, startBackends(t, "a", "b", "c"))
+
+	// the same client from another port is the same key
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.7:1234"
+	want := get(t, p, r)
+	for port := range 5 {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.7:" + strconv.Itoa(5000+port)
+		if got := get(t, p, r); got != want {
+			t.Fatalf("client went to %s, before to %s", got, want)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	backends := startBackends(t, "a", "b")
+	p := newProxy(t, &RoundRobin{}, backends)
+	p.HealthInterval = 10 * time.Millisecond
+
+	type change struct {
+		url     string
+		healthy bool
+	}
+	changes := make(chan change, 10)
+	p.OnChange = func(b *Backend, healthy bool) {
+		changes <- change{b.URL.String(), healthy}
+	}
+	wait := func(want change) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Fatalf("change %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change, want %+v", want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.RunHealthChecks(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	backends[1].down.Store(true)
+	wait(change{backends[1].srv.URL, false})
+	for range 4 {
+		if got := get(t, p, httptest.NewRequest("GET", "/", nil)); got != "a" {
+			t.Fatalf("request went to the ejected backend %s", got)
+		}
+	}
+
+	backends[1].down.Store(false)
+	wait(change{backends[1].srv.URL, true})
+	seen := map[string]bool{}
+	for range 4 {
+		seen[get(t, p, httptest.NewRequest("GET", "/", nil))] = true
+	}
+	if !seen["b"] {
+		t.Fatal("the restored backend gets no requests")
+	}
+}
+
+func TestRetryOnDeadBackend(t *testing.T) {
+	backends := startBackends(t, "a", "b")
+	p := newProxy(t, &RoundRobin{}, backends)
+	backends[0].srv.Close() // connection refused
+
+	for range 4 {
+		if got := get(t, p, httptest.NewRequest("GET", "/", nil)); got != "b" {
+			t.Fatalf("got %s, want b", got)
+		}
+	}
+	if p.backends[0].Healthy() {
+		t.Error("failed requests didn't eject the dead backend")
+	}
+}
+
+func TestClientGoneIsNotABackendFailure(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // answers nothing until the proxy gives up
+	}))
+	t.Cleanup(slow.Close)
+	p, err := New(LeastOutstanding{}, slow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		cancel()
+	}
+	b := p.Backends()[0]
+	if !b.Healthy() {
+		t.Error("clients that hung up ejected the backend")
+	}
+	if n := b.Outstanding(); n != 0 {
+		t.Errorf("%d outstanding requests, want 0", n)
+	}
+}
+
+func TestClientGoneMidStream(t *testing.T) {
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for {
+			if _, err := io.WriteString(w, "data: tick\n\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(stream.Close)
+	p, err := New(LeastOutstanding{}, stream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(p) // the ReverseProxy aborts the handler with a panic, http.Server recovers it
+	t.Cleanup(front.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", front.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel() // in the middle of the stream
+	resp.Body.Close()
+
+	b := p.Backends()[0]
+	for deadline := time.Now().Add(5 * time.Second); b.Outstanding() != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d outstanding requests after the client left, want 0", b.Outstanding())
+		}
+	}
+	if !b.Healthy() {
+		t.Error("a client that left ejected the backend")
+	}
+}
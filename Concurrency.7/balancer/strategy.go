@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Strategy picks the backend for a request from the healthy ones that
+// were not tried yet, candidates is never empty
+type Strategy interface {
+	Pick(candidates []*Backend, r *http.Request) *Backend
+}
+
+// RoundRobin gives every backend the next request in turn
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (s *RoundRobin) Pick(candidates []*Backend, _ *http.Request) *Backend {
+	n := s.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// LeastOutstanding picks the backend with the fewest requests in progress,
+// slow backends get less work by themselves
+type LeastOutstanding struct{}
+
+func (LeastOutstanding) Pick(candidates []*Backend, _ *http.Request) *Backend {
+	best := candidates[0]
+	for _, b := range candidates[1:] {
+		if b.Outstanding() < best.Outstanding() {
+			best = b
+		}
+	}
+	return best
+}
+
+// ConsistentHash sends the same key to the same backend, so a backend can
+// cache what belongs to the key. When a backend is ejected only its keys
+// move, the others stay where they are (a plain hash % n would move almost
+// all of them).
+//
+// It's rendezvous hashing: every backend gets a score hash(key, backend) and
+// the highest score wins. There is no ring to build or to keep in sync with
+// the backends, and with a handful of backends the loop is cheap.
+type ConsistentHash struct {
+	Key func(*http.Request) string // what the request is about, the task name for RealServ; nil means the client IP
+}
+
+func (s ConsistentHash) Pick(candidates []*Backend, r *http.Request) *Backend {
+	var key string
+	if s.Key != nil {
+		key = s.Key(r)
+	} else {
+		key = clientIP(r)
+	}
+
+	var best *Backend
+	var bestScore uint64
+	for _, b := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.URL.Host))
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// mix spreads the bits of fnv, its high bits alone are not random enough
+// for short keys (splitmix64 finalizer)
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// clientIP is the address without the port, every connection of a client
+// has another port
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"conc/runloop"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/********
+### TCP chat ###
+*********/
+
+/*
+Server above is a goroutine with a message channel, a stop channel and a
+select loop. ChatServer is the same thing with real clients: every
+connection has a reader goroutine that sends lines to the hub and a writer
+goroutine that gets lines from its own channel. Only the hub goroutine
+touches the rooms and nicknames, so there is no mutex around them.
+
+	NewChatServer().ListenAndServe(":9000")
+
+	nc localhost 9000
+	/nick alice
+	/join go
+	hello everybody          -> [#go] alice: hello everybody
+	/msg bob psst            -> [pm from alice] psst
+	/msg #go hi              -> to a room you are in, not only the current one
+	/leave go
+	/quit
+
+A client that doesn't read its messages (the buffer of its channel is full
+or a write takes longer than WriteTimeout) is disconnected, one slow client
+must not stop the broadcast to everybody else. A client that doesn't send
+anything for IdleTimeout is disconnected too.
+*/
+
+// ChatServer is a line based chat over TCP, create it with NewChatServer
+type ChatServer struct {
+	IdleTimeout  time.Duration // no line from the client in this time: disconnect
+	WriteTimeout time.Duration // one line to the client takes longer: disconnect
+
+	joinch  chan *chatClient
+	msgch   chan chatLine // like Server.msgch, every line of every client
+	leavech chan chatLeave
+	quitch  chan struct{} // like Server.ch, closed by Stop
+	done    chan struct{} // closed when the hub is gone
+	once    sync.Once
+
+	// owned by the hub goroutine
+	clients map[*chatClient]struct{}
+	nicks   map[string]*chatClient
+	rooms   map[string]map[*chatClient]struct{}
+	guests  int
+}
+
+type chatClient struct {
+	conn net.Conn
+	out  chan string // the writer goroutine sends these, only the hub closes it
+
+	// owned by the hub goroutine
+	nick    string
+	rooms   map[string]struct{}
+	current string // plain text goes to this room
+}
+
+type chatLine struct {
+	client *chatClient
+	text   string
+}
+
+type chatLeave struct {
+	client *chatClient
+	reason string // sent to the client before the connection closes, "" for none
+}
+
+const (
+	chatMaxLine = 1024
+	chatOutbox  = 256 // lines waiting for a client, more means it's too slow
+)
+
+var chatName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,20}$`)
+
+func NewChatServer() *ChatServer {
+	return &ChatServer{
+		IdleTimeout:  5 * time.Minute,
+		WriteTimeout: 5 * time.Second,
+
+		joinch:  make(chan *chatClient),
+		msgch:   make(chan chatLine, 64),
+		leavech: make(chan chatLeave),
+		quitch:  make(chan struct{}),
+		done:    make(chan struct{}),
+
+		clients: make(map[*chatClient]struct{}),
+		nicks:   make(map[string]*chatClient),
+		rooms:   make(map[string]map[*chatClient]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and calls Serve
+func (s *ChatServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts clients until Stop, then waits for their goroutines
+func (s *ChatServer) Serve(ln net.Listener) error {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	go s.hub()
+
+	// Accept blocks, closing the listener is the way to stop it
+	go func() {
+		<-s.done
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.Stop()
+			return err
+		}
+
+		c := &chatClient{
+			conn:  conn,
+			out:   make(chan string, chatOutbox),
+			rooms: make(map[string]struct{}),
+		}
+		select {
+		case s.joinch <- c:
+		case <-s.done:
+			conn.Close()
+			return nil
+		}
+
+		conns.Add(2)
+		go func() {
+			defer conns.Done()
+			s.readLoop(c)
+		}()
+		go func() {
+			defer conns.Done()
+			s.writeLoop(c)
+		}()
+	}
+}
+
+// Stop disconnects everybody and makes Serve return
+func (s *ChatServer) Stop() {
+	s.once.Do(func() { close(s.quitch) })
+	<-s.done
+}
+
+func (s *ChatServer) readLoop(c *chatClient) {
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 256), chatMaxLine)
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		select {
+		case s.msgch <- chatLine{client: c, text: strings.TrimSpace(scanner.Text())}:
+		case <-s.done:
+			return
+		}
+	}
+
+	reason := ""
+	var ne net.Error
+	switch err := scanner.Err(); {
+	case errors.As(err, &ne) && ne.Timeout():
+		reason = "* idle for too long, bye"
+	case errors.Is(err, bufio.ErrTooLong):
+		reason = "* line too long, bye"
+	}
+
+	select {
+	case s.leavech <- chatLeave{client: c, reason: reason}:
+	case <-s.done:
+	}
+}
+
+// writeLoop is the only goroutine writing to the connection
+func (s *ChatServer) writeLoop(c *chatClient) {
+	defer c.conn.Close() // this also stops readLoop
+
+	for line := range c.out {
+		c.conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+			return // too slow or gone, readLoop sees the closed connection
+		}
+	}
+}
+
+// hub is the select loop of Server, it owns all the state
+func (s *ChatServer) hub() {
+	defer close(s.done)
+
+	for {
+		select {
+		case c := <-s.joinch:
+			// /nick guest-3 is allowed, so a guest number may be taken already
+			for {
+				s.guests++
+				c.nick = fmt.Sprintf("guest-%d", s.guests)
+				if _, taken := s.nicks[c.nick]; !taken {
+					break
+				}
+			}
+			s.clients[c] = struct{}{}
+			s.nicks[c.nick] = c
+			s.send(c, "* welcome "+c.nick+", commands: /nick name, /join room, /leave room, /msg name|#room text, /quit")
+
+		case m := <-s.msgch:
+			if _, ok := s.clients[m.client]; ok { // lines of a dropped client may still be queued
+				s.handle(m.client, m.text)
+			}
+
+		case l := <-s.leavech:
+			if l.reason != "" {
+				s.send(l.client, l.reason)
+			}
+			s.drop(l.client)
+
+		case <-s.quitch:
+			for c := range s.clients {
+				s.send(c, "* server is stopping")
+				s.drop(c)
+			}
+			return
+		}
+	}
+}
+
+func (s *ChatServer) handle(c *chatClient, text string) {
+	if text == "" {
+		return
+	}
+	if !strings.HasPrefix(text, "/") {
+		if c.current == "" {
+			s.send(c, "* join a room first: /join room")
+			return
+		}
+		s.broadcast(c.current, fmt.Sprintf("[#%s] %s: %s", c.current, c.nick, text))
+		return
+	}
+
+	cmd, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "/nick":
+		if !chatName.MatchString(arg) {
+			s.send(c, "* a nickname is 1-20 letters, digits, _ or -")
+			return
+		}
+		if _, taken := s.nicks[arg]; taken {
+			s.send(c, "* "+arg+" is taken")
+			return
+		}
+		old := c.nick
+		delete(s.nicks, old)
+		c.nick = arg
+		s.nicks[arg] = c
+		s.send(c, "* you are "+arg)
+		for room := range c.rooms {
+			s.broadcast(room, fmt.Sprintf("* %s is now %s", old, arg))
+		}
+
+	case "/join":
+		room := strings.TrimPrefix(arg, "#")
+		if !chatName.MatchString(room) {
+			s.send(c, "* a room name is 1-20 letters, digits, _ or -")
+			return
+		}
+		c.current = room
+		if _, ok := c.rooms[room]; ok {
+			s.send(c, "* talking in #"+room)
+			return
+		}
+		if s.rooms[room] == nil {
+			s.rooms[room] = make(map[*chatClient]struct{})
+		}
+		s.rooms[room][c] = struct{}{}
+		c.rooms[room] = struct{}{}
+		s.broadcast(room, fmt.Sprintf("* %s joined #%s (%s)", c.nick, room, s.members(room)))
+
+	case "/leave":
+		room := strings.TrimPrefix(arg, "#")
+		if room == "" {
+			room = c.current
+		}
+		if _, ok := c.rooms[room]; !ok {
+			s.send(c, "* you are not in #"+room)
+			return
+		}
+		s.broadcast(room, fmt.Sprintf("* %s left #%s", c.nick, room))
+		s.part(c, room)
+
+	case "/msg":
+		to, msg, _ := strings.Cut(arg, " ")
+		msg = strings.TrimSpace(msg)
+		if to == "" || msg == "" {
+			s.send(c, "* usage: /msg name|#room text")
+			return
+		}
+		if room, ok := strings.CutPrefix(to, "#"); ok {
+			if _, in := c.rooms[room]; !in {
+				s.send(c, "* you are not in #"+room)
+				return
+			}
+			s.broadcast(room, fmt.Sprintf("[#%s] %s: %s", room, c.nick, msg))
+			return
+		}
+		peer, ok := s.nicks[to]
+		if !ok {
+			s.send(c, "* no such user "+to)
+			return
+		}
+		s.send(peer, fmt.Sprintf("[pm from %s] %s", c.nick, msg))
+
+	case "/quit":
+		s.send(c, "* bye")
+		s.drop(c)
+
+	default:
+		s.send(c, "* unknown command "+cmd)
+	}
+}
+
+// send never blocks the hub: a client whose buffer is full is dropped
+func (s *ChatServer) send(c *chatClient, line string) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.out <- line:
+	default:
+		s.drop(c)
+	}
+}
+
+func (s *ChatServer) broadcast(room, line string) {
+	for c := range s.rooms[room] {
+		s.send(c, line)
+	}
+}
+
+// drop forgets the client and closes its channel, the writer sends what is
+// left and closes the connection
+func (s *ChatServer) drop(c *chatClient) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	delete(s.clients, c)
+	delete(s.nicks, c.nick)
+	close(c.out)
+
+	for room := range c.rooms {
+		s.part(c, room)
+		s.broadcast(room, fmt.Sprintf("* %s left #%s", c.nick, room))
+	}
+}
+
+func (s *ChatServer) part(c *chatClient, room string) {
+	delete(c.rooms, room)
+	delete(s.rooms[room], c)
+	if len(s.rooms[room]) == 0 {
+		delete(s.rooms, room)
+	}
+	if c.current == room {
+		c.current = ""
+	}
+}
+
+func (s *ChatServer) members(room string) string {
+	var nicks []string
+	for c := range s.rooms[room] {
+		nicks = append(nicks, c.nick)
+	}
+	sort.Strings(nicks)
+	return strings.Join(nicks, ", ")
+}
+
+// a chat on localhost with two clients, each client is just a net.Conn
+
+func MakeExampleChat() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleChat")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0") // port 0: any free port
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	chat := NewChatServer()
+	chat.IdleTimeout = 300 * time.Millisecond
+
+	type client struct {
+		conn net.Conn
+		r    *bufio.Reader
+	}
+	connect := func() (client, error) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return client{}, err
+		}
+		c := client{conn: conn, r: bufio.NewReader(conn)}
+		c.r.ReadString('\n') // the welcome line
+		return c, nil
+	}
+	say := func(c client, line string) {
+		fmt.Fprintln(c.conn, line)
+	}
+	hear := func(name string, c client) {
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			fmt.Println(name, "<-", err)
+			return
+		}
+		fmt.Print(name, " <- ", line)
+	}
+
+	// the server runs until the clients are done, then Stop disconnects
+	// whoever is left and Serve returns
+	var g runloop.Group
+	g.Add("chat", func(context.Context) error {
+		return chat.Serve(ln)
+	}, func(context.Context) error {
+		chat.Stop()
+		return nil
+	})
+	g.Add("clients", func(context.Context) error {
+		alice, err := connect()
+		if err != nil {
+			return err
+		}
+		defer alice.conn.Close()
+		bob, err := connect()
+		if err != nil {
+			return err
+		}
+		defer bob.conn.Close()
+
+		say(alice, "/nick alice")
+		hear("alice", alice)
+		say(bob, "/nick bob")
+		hear("bob", bob)
+
+		say(alice, "/join go")
+		hear("alice", alice)
+		say(bob, "/join #go")
+		hear("alice", alice)
+		hear("bob", bob)
+
+		say(alice, "hi bob")
+		hear("alice", alice)
+		hear("bob", bob)
+
+		say(alice, "/msg bob only for you")
+		hear("bob", bob)
+
+		say(bob, "/leave")
+		hear("alice", alice)
+		hear("bob", bob)
+
+		// nobody says anything now, after IdleTimeout both are disconnected
+		hear("alice", alice)
+		hear("bob", bob)
+		return nil
+	}, nil)
+	runloop.Exit(g.Run(context.Background()))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startChat serves a chat on a loopback port until the test ends
+func startChat(t *testing.T, idle time.Duration) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := NewChatServer()
+	chat.IdleTimeout = idle
+
+	served := make(chan error, 1)
+	go func() { served <- chat.Serve(ln) }()
+	t.Cleanup(func() {
+		chat.Stop()
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+type chatConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	nick string // from the welcome line
+}
+
+func dialChat(t *testing.T, addr string) *chatConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &chatConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+	welcome := c.hear()
+	nick, ok := strings.CutPrefix(welcome, "* welcome ")
+	if !ok {
+		t.Fatalf("welcome line %q", welcome)
+	}
+	c.nick, _, _ = strings.Cut(nick, ",")
+	return c
+}
+
+func (c *chatConn) say(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// hear reads the next line, there must be one within a second
+func (c *chatConn) hear() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func (c *chatConn) expect(want string) {
+	c.t.Helper()
+	if got := c.hear(); got != want {
+		c.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestChatNicks(t *testing.T) {
+	addr := startChat(t, time.Minute)
+
+	a := dialChat(t, addr)
+	if a.nick != "guest-1" {
+		t.Fatalf("first guest is %q", a.nick)
+	}
+	a.say("/nick guest-2") // the next guest number
+	a.expect("* you are guest-2")
+
+	b := dialChat(t, addr)
+	if b.nick == "guest-2" {
+		t.Fatal("a new guest got a taken nick")
+	}
+
+	b.say("/nick guest-2")
+	b.expect("* guest-2 is taken")
+	b.say("/nick no spaces")
+	b.expect("* a nickname is 1-20 letters, digits, _ or -")
+
+	// a nick is free again when its client is gone
+	a.say("/quit")
+	a.expect("* bye")
+	b.say("/nick guest-2")
+	b.expect("* you are guest-2")
+}
+
+func TestChatRooms(t *testing.T) {
+	addr := startChat(t, time.Minute)
+	alice, bob := dialChat(t, addr), dialChat(t, addr)
+	alice.say("/nick alice")
+	alice.expect("* you are alice")
+	bob.say("/nick bob")
+	bob.expect("* you are bob")
+
+	alice.say("hello?")
+	alice.expect("* join a room first: /join room")
+
+	alice.say("/join go")
+	alice.expect("* alice joined #go (alice)")
+	bob.say("/join #go")
+	alice.expect("* bob joined #go (alice, bob)")
+	bob.expect("* bob joined #go (alice, bob)")
+
+	alice.say("hi bob")
+	alice.expect("[#go] alice: hi bob")
+	bob.expect("[#go] alice: hi bob")
+
+	// only members get the lines of a room
+	alice.say("/join rust")
+	alice.expect("* alice joined #rust (alice)")
+	alice.say("anybody here?")
+	alice.expect("[#rust] alice: anybody here?")
+	alice.say("/msg #go back in go")
+	alice.expect("[#go] alice: back in go")
+	bob.expect("[#go] alice: back in go")
+
+	bob.say("/msg #rust let me in")
+	bob.expect("* you are not in #rust")
+
+	bob.say("/leave")
+	alice.expect("* bob left #go")
+	bob.expect("* bob left #go")
+	bob.say("still here?")
+	bob.expect("* join a room first: /join room")
+
+	// a client that is gone leaves its rooms
+	bob.say("/join go")
+	alice.expect("* bob joined #go (alice, bob)")
+	bob.expect("* bob joined #go (alice, bob)")
+	alice.conn.Close()
+	bob.expect("* alice left #go")
+	bob.say("/leave")
+	bob.expect("* bob left #go")
+	bob.say("/join go")
+	bob.expect("* bob joined #go (bob)")
+}
+
+func TestChatPrivateMessage(t *testing.T) {
+	addr := startChat(t, time.Minute)
+	alice, bob, eve := dialChat(t, addr), dialChat(t, addr), dialChat(t, addr)
+	alice.say("/nick alice")
+	alice.expect("* you are alice")
+
+	bob.say("/msg alice only for you")
+	alice.expect("[pm from " + bob.nick + "] only for you")
+
+	bob.say("/msg nobody hello")
+	bob.expect("* no such user nobody")
+	bob.say("/msg alice")
+	bob.expect("* usage: /msg name|#room text")
+
+	// eve got nothing: the next line she hears is the answer to her own command
+	eve.say("/nick eve")
+	eve.expect("* you are eve")
+}
+
+func TestChatIdleTimeout(t *testing.T) {
+	addr := startChat(t, 100*time.Millisecond)
+	quiet, talker := dialChat(t, addr), dialChat(t, addr)
+
+	// talker keeps talking, quiet says nothing
+	for range 4 {
+		time.Sleep(40 * time.Millisecond)
+		talker.say("/nick talker")
+		talker.hear()
+	}
+
+	quiet.expect("* idle for too long, bye")
+	quiet.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := quiet.r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("after the idle message: %v, want EOF", err)
+	}
+
+	talker.say("/msg talker still here")
+	talker.expect("[pm from talker] still here")
+}
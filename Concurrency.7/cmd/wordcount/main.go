@@ -0,0 +1,69 @@
+// Command wordcount counts words, lines and bytes of a directory tree
+//
+//	go run ./cmd/wordcount -top 20 -v ~/src
+//
+// Ctrl-C stops it at once and prints what was counted so far.
+package main
+
+import (
+	"conc/wordcount"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+func main() {
+	workers := flag.Int("workers", runtime.NumCPU(), "files counted at the same time")
+	top := flag.Int("top", 10, "show the most frequent words")
+	verbose := flag.Bool("v", false, "print every file as soon as it's counted")
+	flag.Parse()
+
+	if *top < 0 {
+		fmt.Fprintln(os.Stderr, "wordcount: -top must be 0 or more")
+		flag.Usage()
+		os.Exit(2) // like flag does for a bad flag
+	}
+
+	root := "."
+	if flag.NArg() > 0 {
+		root = flag.Arg(0)
+	}
+
+	// the first Ctrl-C cancels ctx, after that the signal is ours no more
+	// and a second one kills the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	var totals wordcount.Totals
+	for r := range wordcount.Count(ctx, root, *workers) {
+		totals.Add(r)
+
+		switch {
+		case r.Err != nil:
+			fmt.Fprintln(os.Stderr, "wordcount:", r.Err)
+		case *verbose && !r.Skipped:
+			fmt.Printf("%8d %8d %10d %s\n", r.Lines, r.Words, r.Bytes, r.Path)
+		}
+	}
+	interrupted := ctx.Err() != nil // stop cancels ctx too, look before
+	stop()
+
+	fmt.Printf("%8d %8d %10d total (%d files, %d binary skipped)\n",
+		totals.Lines, totals.Words, totals.Bytes, totals.Files, totals.Skipped)
+	for i, wc := range totals.Top(*top) {
+		fmt.Printf("%3d. %-20s %d\n", i+1, wc.Word, wc.Count)
+	}
+
+	switch {
+	case interrupted:
+		fmt.Fprintln(os.Stderr, "wordcount: interrupted, the counts are partial")
+		os.Exit(130) // what shells use for a process stopped by SIGINT
+	case totals.Errors > 0:
+		os.Exit(1)
+	}
+}
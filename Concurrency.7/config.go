@@ -0,0 +1,195 @@
+package main
+
+import (
+	"conc/admission"
+	"conc/hotconfig"
+	"conc/panics"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+/********
+### Hot-reloadable config ###
+*********/
+
+/*
+The settings of RealServ live in a hotconfig.ConfigStore. Every handler calls
+r.config.Load() when it needs a value, so a new file takes effect for the
+next request without a restart and without a mutex on the hot path.
+
+	{
+		"workers": 4,
+		"request_timeout": "5s",
+		"task_duration": "2s",
+		"admission": {
+			"default_tier": "free",
+			"tiers": {
+				"free": {"rate": 5, "burst": 10, "max_in_flight": 2},
+				"pro": {"rate": 100, "burst": 200, "max_in_flight": 32}
+			},
+			"clients": {"secret-key-1": "pro"}
+		}
+	}
+
+Fields that are missing keep their defaults. A file that doesn't parse or
+doesn't pass Validate is logged and ignored, the server keeps the old config.
+*/
+
+// ServerConfig is everything RealServ reads from the config file
+type ServerConfig struct {
+	Workers        int                `json:"workers"`          // goroutines that run tasks of HandleRequest
+	RequestTimeout hotconfig.Duration `json:"request_timeout"`  // HandleRequest gives up after this
+	TaskDuration   hotconfig.Duration `json:"task_duration"`    // how long the simulated work takes
+	Heartbeat      hotconfig.Duration `json:"sse_heartbeat"`    // how often event streams send a keep-alive comment
+	WSMaxInFlight  int64              `json:"ws_max_in_flight"` // tasks one websocket connection may run at the same time
+	WSPingInterval hotconfig.Duration `json:"ws_ping_interval"` // how often we ping websocket clients, see websocket.go
+
+	Admission admission.Config `json:"admission"` // quotas per client tier, see admission
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Workers:        1,
+		RequestTimeout: hotconfig.Duration(5 * time.Second),
+		TaskDuration:   hotconfig.Duration(2 * time.Second),
+		Heartbeat:      hotconfig.Duration(15 * time.Second),
+		WSMaxInFlight:  8,
+		WSPingInterval: hotconfig.Duration(20 * time.Second),
+
+		Admission: admission.Config{
+			DefaultTier: "free",
+			Tiers: map[string]admission.Quota{
+				"free": {Rate: 5, Burst: 10, MaxInFlight: 2},
+			},
+		},
+	}
+}
+
+// Validate rejects configs the server can't work with
+func (c *ServerConfig) Validate() error {
+	var errs []error
+	if c.Workers < 1 || c.Workers > 1024 {
+		errs = append(errs, fmt.Errorf("workers must be 1..1024, got %d", c.Workers))
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("request_timeout must be positive"))
+	}
+	if c.TaskDuration < 0 {
+		errs = append(errs, errors.New("task_duration can't be negative"))
+	}
+	if c.Heartbeat < hotconfig.Duration(time.Second) {
+		errs = append(errs, errors.New("sse_heartbeat must be at least 1s"))
+	}
+	if c.WSMaxInFlight < 1 {
+		errs = append(errs, errors.New("ws_max_in_flight must be at least 1"))
+	}
+	if c.WSPingInterval < hotconfig.Duration(time.Second) {
+		errs = append(errs, errors.New("ws_ping_interval must be at least 1s"))
+	}
+	if err := c.Admission.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("admission: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// WatchConfig loads the file and keeps reloading it until ctx is done.
+// Only a bad file at the first load returns an error, later bad versions
+// are logged. A missing file is not an error: the defaults are used and
+// the file is loaded as soon as it's created.
+func (r *RealServ) WatchConfig(ctx context.Context, path string) error {
+	err := r.config.LoadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.logger.Info("no config file, using the defaults until it's created", "path", path)
+	case err != nil:
+		return err
+	}
+
+	go r.config.Watch(ctx, path, time.Second, func(err error) {
+		r.logger.Error("config reload failed", "err", err)
+	})
+	return nil
+}
+
+// runWorkers keeps config.Workers goroutines reading taskCh and starts or
+// stops some when the config changes, it returns after doneCh is closed
+// and every worker finished its task
+func (r *RealServ) runWorkers() {
+	updates, unsubscribe := r.config.Subscribe()
+	defer unsubscribe()
+
+	quit := make(chan struct{}) // every value stops one worker, when it's free
+	var wg sync.WaitGroup
+	workers := 0
+
+	resize := func(n int) {
+		for ; workers < n; workers++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.worker(quit)
+			}()
+		}
+		if workers > n {
+			// busy workers take the signal after their task, don't wait for them here
+			go func(extra int) {
+				for range extra {
+					select {
+					case quit <- struct{}{}:
+					case <-r.doneCh:
+						return
+					}
+				}
+			}(workers - n)
+			workers = n
+		}
+	}
+
+	resize(r.config.Load().Workers)
+	for {
+		select {
+		case cfg := <-updates:
+			if cfg.Workers != workers {
+				r.logger.Info("workers resized", "from", workers, "to", cfg.Workers)
+			}
+			resize(cfg.Workers)
+		case <-r.doneCh:
+			wg.Wait()
+			return
+		}
+	}
+}
+
+func (r *RealServ) worker(quit <-chan struct{}) {
+	for {
+		select {
+		case req := <-r.taskCh:
+			// WithoutCancel keeps the request id for the logs, but the task
+			// is not canceled when the client goes away
+			ctx := context.WithoutCancel(req.ctx)
+
+			var result string
+			err := panics.Call(func() (err error) {
+				result, err = r.process(ctx, req.task)
+				return err
+			})
+			var p *panics.PanicError
+			if errors.As(err, &p) {
+				// one broken task must not take the worker (and the server) down
+				r.logger.ErrorContext(ctx, "task panicked", "task", req.task, "panic", p.Value, "stack", string(p.Stack))
+				result = "internal error"
+			}
+
+			req.reply <- result // buffered, never blocks even if HandleRequest gave up
+
+		case <-quit:
+			return
+		case <-r.doneCh:
+			return
+		}
+	}
+}
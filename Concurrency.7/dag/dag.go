@@ -0,0 +1,325 @@
+// Package dag runs tasks that depend on each other: a task starts only when
+// all its dependencies are done, independent tasks run in parallel
+package dag
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Task is a node of the graph, Deps are names of tasks that must finish first
+type Task struct {
+	Name string
+	Deps []string
+	Run  func(ctx context.Context) error
+}
+
+// Status of a task after Run
+type Status int
+
+const (
+	Pending   Status = iota // never started
+	Succeeded               // Run returned nil
+	Failed                  // Run returned an error
+	Skipped                 // a dependency failed or was skipped
+	Canceled                // the context was done before the task could start
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Succeeded:
+		return "succeeded"
+	case Failed:
+		return "failed"
+	case Skipped:
+		return "skipped"
+	case Canceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// TaskReport says what happened to one task
+type TaskReport struct {
+	Name     string
+	Status   Status
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Report has a TaskReport for every task, in the order the tasks were added
+type Report []TaskReport
+
+// Get returns the report of the task with the given name
+func (r Report) Get(name string) (TaskReport, bool) {
+	for _, t := range r {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return TaskReport{}, false
+}
+
+// CycleError is returned when tasks depend on each other in a circle
+type CycleError struct {
+	Path []string // a -> b -> c -> a
+}
+
+func (e *CycleError) Error() string {
+	return "dag: cycle " + strings.Join(e.Path, " -> ")
+}
+
+// Graph collects tasks, the zero value is an empty graph
+type Graph struct {
+	tasks []Task
+	index map[string]int
+}
+
+// Add puts a task into the graph, names must be unique
+func (g *Graph) Add(t Task) error {
+	if t.Run == nil {
+		return fmt.Errorf("dag: task %q has no Run func", t.Name)
+	}
+	if g.index == nil {
+		g.index = make(map[string]int)
+	}
+	if _, ok := g.index[t.Name]; ok {
+		return fmt.Errorf("dag: task %q already added", t.Name)
+	}
+	g.index[t.Name] = len(g.tasks)
+	g.tasks = append(g.tasks, t)
+	return nil
+}
+
+// Validate checks that every dependency exists and there are no cycles
+func (g *Graph) Validate() error {
+	for _, t := range g.tasks {
+		for _, dep := range t.Deps {
+			if _, ok := g.index[dep]; !ok {
+				return fmt.Errorf("dag: task %q depends on unknown task %q", t.Name, dep)
+			}
+		}
+	}
+
+	// depth first search, a gray node we meet again is a cycle
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := make([]int, len(g.tasks))
+	var stack []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		color[i] = gray
+		stack = append(stack, g.tasks[i].Name)
+
+		for _, dep := range g.tasks[i].Deps {
+			j := g.index[dep]
+			switch color[j] {
+			case gray:
+				// cut the stack from the first time we saw dep
+				start := 0
+				for k, name := range stack {
+					if name == dep {
+						start = k
+					}
+				}
+				path := append(append([]string(nil), stack[start:]...), dep)
+				return &CycleError{Path: path}
+			case white:
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		color[i] = black
+		return nil
+	}
+
+	for i := range g.tasks {
+		if color[i] == white {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type finished struct {
+	index int
+	err   error
+	end   time.Time
+}
+
+// Run validates the graph and executes it with at most limit tasks at the same time
+// (limit <= 0 means no limit)
+//
+// When a task fails, everything that depends on it is skipped, the other
+// branches keep running. When ctx is done no new tasks start. The returned
+// error joins the errors of failed tasks (and ctx.Err() if it was canceled).
+// A task that panics fails with a *panics.PanicError.
+func (g *Graph) Run(ctx context.Context, limit int) (Report, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	n := len(g.tasks)
+	report := make(Report, n)
+	waiting := make([]int, n)      // how many deps are not done yet
+	dependents := make([][]int, n) // who waits for this task
+	for i, t := range g.tasks {
+		report[i] = TaskReport{Name: t.Name}
+		waiting[i] = len(t.Deps)
+		for _, dep := range t.Deps {
+			j := g.index[dep]
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range g.tasks {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	// buffered, so a task can always report even if we stopped reading
+	done := make(chan finished, n)
+	running, left := 0, n
+
+	// skip marks all tasks that depend on i, directly or not
+	var skip func(i int)
+	skip = func(i int) {
+		for _, d := range dependents[i] {
+			if report[d].Status != Pending {
+				continue
+			}
+			report[d].Status = Skipped
+			left--
+			skip(d)
+		}
+	}
+
+	for left > 0 {
+		// start what we can
+		for len(ready) > 0 && (limit <= 0 || running < limit) && ctx.Err() == nil {
+			i := ready[0]
+			ready = ready[1:]
+			if report[i].Status != Pending {
+				continue
+			}
+
+			running++
+			report[i].Start = time.Now()
+			go func(i int) {
+				err := panics.Call(func() error { return g.tasks[i].Run(ctx) })
+				done <- finished{index: i, err: err, end: time.Now()}
+			}(i)
+		}
+
+		if running == 0 {
+			break // the context is done and nothing runs anymore
+		}
+
+		var f finished
+		select {
+		case f = <-done:
+		case <-ctx.Done():
+			f = <-done // running tasks got ctx, we wait for them to return
+		}
+
+		running--
+		left--
+		r := &report[f.index]
+		r.Duration = f.end.Sub(r.Start)
+
+		if f.err != nil {
+			r.Status = Failed
+			r.Err = f.err
+			skip(f.index)
+			continue
+		}
+
+		r.Status = Succeeded
+		for _, d := range dependents[f.index] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	var errs []error
+	for i := range report {
+		switch report[i].Status {
+		case Pending:
+			report[i].Status = Canceled
+		case Failed:
+			errs = append(errs, fmt.Errorf("task %q: %w", report[i].Name, report[i].Err))
+		}
+	}
+	if err := ctx.Err(); err != nil && hasStatus(report, Canceled) {
+		errs = append(errs, err)
+	}
+
+	return report, errors.Join(errs...)
+}
+
+func hasStatus(r Report, s Status) bool {
+	for _, t := range r {
+		if t.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DOT writes the graph in graphviz format, an edge goes from a dependency to the task
+// with a report the nodes are colored by status
+//
+//	go run . | dot -Tpng > graph.png
+func (g *Graph) DOT(report Report) string {
+	colors := map[Status]string{
+		Succeeded: "palegreen",
+		Failed:    "salmon",
+		Skipped:   "lightgray",
+		Canceled:  "khaki",
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph dag {\n\trankdir=LR;\n")
+
+	for _, t := range g.tasks {
+		fmt.Fprintf(&b, "\t%q", t.Name)
+		if r, ok := report.Get(t.Name); ok {
+			if color, ok := colors[r.Status]; ok {
+				fmt.Fprintf(&b, " [style=filled, fillcolor=%s, tooltip=%q]", color, r.Status.String()+" in "+r.Duration.String())
+			}
+		}
+		b.WriteString(";\n")
+	}
+
+	for _, t := range g.tasks {
+		deps := append([]string(nil), t.Deps...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", dep, t.Name)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,69 @@
+// Package deadlock has drop-in replacements for sync.Mutex and sync.RWMutex
+// that look for potential deadlocks
+//
+// In normal builds DebugMutex is just a sync.Mutex. Build with the lockdebug tag
+//
+//	go run -tags lockdebug .
+//
+// and every lock remembers the order in which goroutines take locks. If one
+// goroutine takes A then B and another one takes B then A, they can deadlock
+// one day, even if they didn't today, and you get a report with the stacks
+// of both acquisitions. Locks held too long are reported too.
+package deadlock
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// InversionReport describes two lock orders that contradict each other
+type InversionReport struct {
+	// the goroutine that is taking Lock while holding Held
+	Held, Lock     string // lock names, like "*deadlock.DebugMutex 0xc000012345"
+	HeldStack      string // where Held was taken
+	LockStack      string // where Lock is being taken now
+	PreviousStacks string // where the opposite order (Lock ... Held) was seen before
+}
+
+func (r InversionReport) String() string {
+	return fmt.Sprintf("POTENTIAL DEADLOCK: lock order inversion\n"+
+		"taking %s while holding %s, but earlier they were taken in the opposite order\n\n"+
+		"--- %s was taken here:\n%s\n"+
+		"--- %s is being taken here:\n%s\n"+
+		"--- the opposite order was seen here:\n%s",
+		r.Lock, r.Held, r.Held, r.HeldStack, r.Lock, r.LockStack, r.PreviousStacks)
+}
+
+// LongHoldReport is sent when a lock is held longer than Opts.HoldThreshold
+type LongHoldReport struct {
+	Lock  string
+	Held  time.Duration
+	Stack string // where the lock was taken
+}
+
+func (r LongHoldReport) String() string {
+	return fmt.Sprintf("lock %s held for more than %v, taken here:\n%s", r.Lock, r.Held, r.Stack)
+}
+
+// Opts configures the checks, it only matters with the lockdebug tag
+// set it before the locks are used
+var Opts = struct {
+	// HoldThreshold reports locks held longer than this, zero turns the check off
+	HoldThreshold time.Duration
+
+	// OnInversion and OnLongHold get the reports, by default they are printed to stderr
+	OnInversion func(InversionReport)
+	OnLongHold  func(LongHoldReport)
+}{
+	HoldThreshold: 30 * time.Second,
+	OnInversion: func(r InversionReport) {
+		fmt.Fprintln(os.Stderr, r)
+	},
+	OnLongHold: func(r LongHoldReport) {
+		fmt.Fprintln(os.Stderr, r)
+	},
+}
+
+// Enabled reports whether the program was built with the lockdebug tag
+const Enabled = enabled
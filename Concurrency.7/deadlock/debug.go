@@ -0,0 +1,325 @@
+//go:build lockdebug
+
+package deadlock
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const enabled = true
+
+// DebugMutex is a sync.Mutex that checks the lock order
+type DebugMutex struct {
+	mu sync.Mutex
+}
+
+func (m *DebugMutex) Lock() {
+	id := lockID(unsafe.Pointer(m))
+	stack := checkOrder(id, m.name())
+	m.mu.Lock()
+	acquired(id, m.name(), stack)
+}
+
+func (m *DebugMutex) TryLock() bool {
+	// TryLock never blocks, so it can't deadlock, but other locks can be taken while it's held
+	if !m.mu.TryLock() {
+		return false
+	}
+	acquired(lockID(unsafe.Pointer(m)), m.name(), callers())
+	return true
+}
+
+func (m *DebugMutex) Unlock() {
+	released(lockID(unsafe.Pointer(m)))
+	m.mu.Unlock()
+}
+
+func (m *DebugMutex) name() string {
+	return fmt.Sprintf("%T %p", m, m)
+}
+
+// DebugRWMutex is a sync.RWMutex that checks the lock order,
+// read locks count as taking the lock too
+type DebugRWMutex struct {
+	mu sync.RWMutex
+}
+
+func (m *DebugRWMutex) Lock() {
+	id := lockID(unsafe.Pointer(m))
+	stack := checkOrder(id, m.name())
+	m.mu.Lock()
+	acquired(id, m.name(), stack)
+}
+
+func (m *DebugRWMutex) TryLock() bool {
+	if !m.mu.TryLock() {
+		return false
+	}
+	acquired(lockID(unsafe.Pointer(m)), m.name(), callers())
+	return true
+}
+
+func (m *DebugRWMutex) Unlock() {
+	released(lockID(unsafe.Pointer(m)))
+	m.mu.Unlock()
+}
+
+func (m *DebugRWMutex) RLock() {
+	id := lockID(unsafe.Pointer(m))
+	stack := checkOrder(id, m.name())
+	m.mu.RLock()
+	acquired(id, m.name(), stack)
+}
+
+func (m *DebugRWMutex) TryRLock() bool {
+	if !m.mu.TryRLock() {
+		return false
+	}
+	acquired(lockID(unsafe.Pointer(m)), m.name(), callers())
+	return true
+}
+
+func (m *DebugRWMutex) RUnlock() {
+	released(lockID(unsafe.Pointer(m)))
+	m.mu.RUnlock()
+}
+
+// RLocker returns a sync.Locker that uses RLock and RUnlock
+func (m *DebugRWMutex) RLocker() sync.Locker {
+	return rlocker{m}
+}
+
+func (m *DebugRWMutex) name() string {
+	return fmt.Sprintf("%T %p", m, m)
+}
+
+type rlocker struct{ m *DebugRWMutex }
+
+func (r rlocker) Lock()   { r.m.RLock() }
+func (r rlocker) Unlock() { r.m.RUnlock() }
+
+// the address of the mutex is its identity, a freed mutex can give its
+// address to a new one, that's fine for a debugging tool
+type lockID uintptr
+
+type holding struct {
+	id    lockID
+	name  string
+	stack string
+	timer *time.Timer // fires when the lock is held too long
+}
+
+type edge struct {
+	from, to lockID
+}
+
+var state = struct {
+	mu sync.Mutex
+
+	held map[int64][]holding // locks every goroutine holds right now
+
+	// order has an edge A -> B when some goroutine took B while holding A,
+	// the value is where that happened
+	order    map[edge]string
+	next     map[lockID][]lockID
+	names    map[lockID]string
+	reported map[edge]bool // every inversion is reported once
+}{
+	held:     make(map[int64][]holding),
+	order:    make(map[edge]string),
+	next:     make(map[lockID][]lockID),
+	names:    make(map[lockID]string),
+	reported: make(map[edge]bool),
+}
+
+// checkOrder runs before we block on the lock, so a real deadlock is reported too
+// it returns the stack of this acquisition
+func checkOrder(id lockID, name string) string {
+	gid := goid()
+	stack := callers()
+
+	var reports []InversionReport
+
+	state.mu.Lock()
+	state.names[id] = name
+
+	for _, h := range state.held[gid] {
+		if h.id == id {
+			continue // recursive read lock, not an ordering problem
+		}
+
+		e := edge{h.id, id}
+		if _, ok := state.order[e]; ok {
+			continue // we have seen this order before and it was fine
+		}
+
+		// taking id while holding h.id is wrong if somebody took h.id after id,
+		// directly or through other locks
+		if path := findPath(id, h.id); path != nil && !state.reported[e] {
+			state.reported[e] = true
+
+			var previous bytes.Buffer
+			for _, p := range path {
+				fmt.Fprintf(&previous, "%s -> %s:\n%s\n", state.names[p.from], state.names[p.to], state.order[p])
+			}
+
+			reports = append(reports, InversionReport{
+				Held:           h.name,
+				Lock:           name,
+				HeldStack:      h.stack,
+				LockStack:      stack,
+				PreviousStacks: previous.String(),
+			})
+		}
+
+		state.order[e] = "--- " + h.name + " taken at:\n" + h.stack + "\n--- " + name + " taken at:\n" + stack
+		state.next[h.id] = append(state.next[h.id], id)
+	}
+	state.mu.Unlock()
+
+	// callbacks run without our lock, they may lock something themselves
+	for _, r := range reports {
+		if Opts.OnInversion != nil {
+			Opts.OnInversion(r)
+		}
+	}
+
+	return stack
+}
+
+// findPath looks for edges from -> ... -> to, state.mu is held
+func findPath(from, to lockID) []edge {
+	prev := map[lockID]lockID{from: from}
+	queue := []lockID{from}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, n := range state.next[cur] {
+			if _, seen := prev[n]; seen {
+				continue
+			}
+			prev[n] = cur
+
+			if n == to {
+				var path []edge
+				for n != from {
+					path = append([]edge{{prev[n], n}}, path...)
+					n = prev[n]
+				}
+				return path
+			}
+			queue = append(queue, n)
+		}
+	}
+	return nil
+}
+
+func acquired(id lockID, name, stack string) {
+	h := holding{id: id, name: name, stack: stack}
+
+	if threshold := Opts.HoldThreshold; threshold > 0 {
+		h.timer = time.AfterFunc(threshold, func() {
+			if Opts.OnLongHold != nil {
+				Opts.OnLongHold(LongHoldReport{Lock: name, Held: threshold, Stack: stack})
+			}
+		})
+	}
+
+	gid := goid()
+	state.mu.Lock()
+	state.held[gid] = append(state.held[gid], h)
+	state.mu.Unlock()
+}
+
+func released(id lockID) {
+	gid := goid()
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if removeHeld(gid, id) {
+		return
+	}
+	// sync.Mutex may be unlocked by another goroutine than the one that locked it
+	for other := range state.held {
+		if removeHeld(other, id) {
+			return
+		}
+	}
+}
+
+// removeHeld drops the last holding of id by the goroutine, state.mu is held
+func removeHeld(gid int64, id lockID) bool {
+	held := state.held[gid]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i].id != id {
+			continue
+		}
+		if held[i].timer != nil {
+			held[i].timer.Stop()
+		}
+		held = append(held[:i], held[i+1:]...)
+		if len(held) == 0 {
+			delete(state.held, gid)
+		} else {
+			state.held[gid] = held
+		}
+		return true
+	}
+	return false
+}
+
+// callers returns the stack of the current goroutine without the frames of this package
+func callers() string {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// the first line is "goroutine N [running]:", then two lines per frame: function and file
+	lines := bytes.Split(bytes.TrimSpace(buf), []byte("\n"))
+	out := [][]byte{lines[0]}
+	i := 1
+	for i+1 < len(lines) && bytes.HasPrefix(lines[i], []byte(pkgPath+".")) {
+		i += 2
+	}
+	out = append(out, lines[i:]...)
+	return string(bytes.Join(out, []byte("\n")))
+}
+
+// pkgPath is the import path of this package, like "conc/deadlock"
+var pkgPath = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name() // "conc/deadlock.init.func1"
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i] + name[i:i+strings.Index(name[i:], ".")]
+	}
+	return name[:strings.Index(name, ".")]
+}()
+
+// goid reads the goroutine id from the first line of the stack: "goroutine 42 [running]:"
+// go hides the id on purpose, it's only fine for debugging
+func goid() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	line := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		line = line[:i]
+	}
+	id, _ := strconv.ParseInt(string(line), 10, 64)
+	return id
+}
@@ -0,0 +1,17 @@
+//go:build !lockdebug
+
+package deadlock
+
+import "sync"
+
+const enabled = false
+
+// DebugMutex is a plain sync.Mutex in normal builds, there is no overhead
+type DebugMutex struct {
+	sync.Mutex
+}
+
+// DebugRWMutex is a plain sync.RWMutex in normal builds, there is no overhead
+type DebugRWMutex struct {
+	sync.RWMutex
+}
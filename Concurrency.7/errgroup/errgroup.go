@@ -0,0 +1,108 @@
+// Package errgroup is a WaitGroup that also collects errors:
+// the first error cancels the shared context and is returned by Wait
+package errgroup
+
+import (
+	"conc/panics"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Group is a set of goroutines working on parts of the same task
+// the zero value has no limit and doesn't cancel anything on error
+type Group struct {
+	cancel func(error)
+
+	wg  sync.WaitGroup
+	sem chan struct{} // one token per running goroutine, nil means no limit
+
+	recoverPanics bool
+
+	errOnce sync.Once
+	err     error
+}
+
+// WithContext returns a Group and a context derived from ctx,
+// the context is canceled when a function returns an error or when Wait returns
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// SetLimit allows at most n goroutines at the same time, n < 0 means no limit
+// it can't be changed while goroutines are running
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("errgroup: modify limit while %v goroutines are still active", len(g.sem)))
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// SetRecoverPanics makes a panic in f an error of the group (a *panics.PanicError),
+// it cancels the context and Wait returns it like any other error
+func (g *Group) SetRecoverPanics(on bool) {
+	g.recoverPanics = on
+}
+
+// Go runs f in a new goroutine, it blocks while the limit is reached
+func (g *Group) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(f)
+}
+
+// TryGo runs f only if the limit allows it right now, it reports whether f was started
+func (g *Group) TryGo(f func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(f)
+	return true
+}
+
+// Wait blocks until all functions returned and gives back the first error
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel(g.err)
+	}
+	return g.err
+}
+
+func (g *Group) start(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+
+		run := f
+		if g.recoverPanics {
+			run = func() error { return panics.Call(f) }
+		}
+
+		if err := run(); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				if g.cancel != nil {
+					g.cancel(err)
+				}
+			})
+		}
+	}()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"conc/admission"
+	"conc/panics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/********
+### Server-Sent Events ###
+*********/
+
+/*
+HandleRequest keeps the client waiting and gives back one string at the end.
+With server-sent events (SSE) the client opens one long GET request and the
+server writes events into it while the task runs:
+
+	id: 3
+	event: progress
+	data: {"percent":50}
+
+The browser (EventSource) reconnects by itself and sends the Last-Event-ID
+header, so we keep the events of every task and send only the missed ones.
+*/
+
+// TaskEvent is one message of the stream
+type TaskEvent struct {
+	ID   int    // grows by one for every event of a task
+	Type string // "progress", "log" or "result"
+	Data string
+}
+
+type trackedTask struct {
+	id   string
+	name string
+
+	mu      sync.Mutex
+	events  []TaskEvent
+	done    bool
+	changed chan struct{} // closed and replaced on every new event, wakes up the streams
+}
+
+// keep finished tasks for a while, so a client can reconnect and get the result
+const finishedTaskTTL = 5 * time.Minute
+
+func (t *trackedTask) emit(typ, data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.events = append(t.events, TaskEvent{ID: len(t.events) + 1, Type: typ, Data: data})
+	if typ == "result" {
+		t.done = true
+	}
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *trackedTask) finished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.done
+}
+
+// since returns events after lastID and a channel to wait for more
+func (t *trackedTask) since(lastID int) ([]TaskEvent, bool, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if lastID < 0 || lastID > len(t.events) {
+		lastID = 0 // an ID we never sent, start from the beginning
+	}
+	events := append([]TaskEvent(nil), t.events[lastID:]...)
+	return events, t.done, t.changed
+}
+
+// HandleCreateTask starts a task in the background and returns its id
+// POST /tasks?task=name
+func (r *RealServ) HandleCreateTask(w http.ResponseWriter, s *http.Request) {
+	name := s.URL.Query().Get("task")
+	if name == "" {
+		http.Error(w, "no task", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case <-r.doneCh:
+		http.Error(w, "server is stopping", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	r.tasksMu.Lock()
+	r.nextID++
+	t := &trackedTask{
+		id:      strconv.FormatInt(r.nextID, 10),
+		name:    name,
+		changed: make(chan struct{}),
+	}
+	r.tasks[t.id] = t
+	r.tasksMu.Unlock()
+
+	// the task outlives the request, but keeps its request id for the logs
+	// and its in-flight slot, a panic in it is logged instead of killing the server
+	release := admission.Hold(s)
+	panics.SafeGo(context.WithoutCancel(s.Context()), func(ctx context.Context) error {
+		defer release()
+		r.runTrackedTask(ctx, t)
+		return nil
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/tasks/"+t.id+"/events")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": t.id})
+}
+
+// runTrackedTask is the same work as TaskHandler, but it reports how far it got
+func (r *RealServ) runTrackedTask(ctx context.Context, t *trackedTask) {
+	defer func() {
+		// a panic skips the result, the streams wait for one to end
+		if !t.finished() {
+			t.emit("result", `{"error":"internal error"}`)
+		}
+		time.AfterFunc(finishedTaskTTL, func() {
+			r.tasksMu.Lock()
+			delete(r.tasks, t.id)
+			r.tasksMu.Unlock()
+		})
+	}()
+
+	r.logger.InfoContext(ctx, "tracked task started", "task", t.name, "id", t.id)
+	t.emit("log", "started "+t.name)
+
+	const steps = 4
+	step := r.config.Load().TaskDuration.D() / steps // as long as a task of HandleRequest
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-time.After(step): // simulation another process
+		case <-r.doneCh:
+			r.logger.WarnContext(ctx, "tracked task canceled", "task", t.name, "id", t.id)
+			t.emit("log", "server is stopping")
+			t.emit("result", `{"error":"canceled"}`)
+			return
+		}
+		t.emit("progress", fmt.Sprintf(`{"percent":%d}`, i*100/steps))
+	}
+
+	r.logger.InfoContext(ctx, "tracked task done", "task", t.name, "id", t.id)
+	result, _ := json.Marshal(map[string]string{"result": "Processed: " + t.name})
+	t.emit("result", string(result))
+}
+
+// HandleTaskEvents streams the events of a task
+// GET /tasks/{id}/events
+func (r *RealServ) HandleTaskEvents(w http.ResponseWriter, s *http.Request) {
+	r.tasksMu.Lock()
+	t, ok := r.tasks[s.PathValue("id")]
+	r.tasksMu.Unlock()
+	if !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, _ := strconv.Atoi(s.Header.Get("Last-Event-ID"))
+
+	events, done, changed := t.since(lastID)
+	if done && len(events) == 0 {
+		// everything was sent already, 204 tells EventSource to stop reconnecting
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(r.config.Load().Heartbeat.D())
+	defer heartbeat.Stop()
+
+	stopping := r.doneCh
+	var grace <-chan time.Time
+
+	for {
+		for _, e := range events {
+			writeEvent(w, e)
+			lastID = e.ID
+		}
+		events = nil
+		flusher.Flush()
+
+		if done {
+			return // the result was sent, the stream is over
+		}
+
+		select {
+		case <-changed:
+			events, done, changed = t.since(lastID)
+
+		case <-heartbeat.C:
+			// a comment line, clients ignore it but proxies see the connection is alive
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+
+		case <-s.Context().Done():
+			return // the client went away
+
+		case <-stopping:
+			// the task sees doneCh too and sends a "canceled" result, wait
+			// for it so the client gets a last event instead of a cut stream
+			stopping = nil
+			grace = time.After(time.Second)
+
+		case <-grace:
+			return // the task didn't finish, don't hold up the shutdown
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e TaskEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", e.ID, e.Type)
+	// every line of the data needs its own "data:" prefix
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,164 @@
+// Package fanout runs several named sources at the same time and collects
+// what they return, instead of calling them one after another
+package fanout
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Source is one thing we want to fetch
+type Source[T any] struct {
+	Name  string
+	Fetch func(ctx context.Context) (T, error)
+
+	// Timeout is only for this source, zero means we wait as long as the parent context
+	Timeout time.Duration
+
+	// Required sources fail the whole call, optional ones only end up in Result.Errors
+	Required bool
+}
+
+// Result has everything that came back, even when some sources failed
+type Result[T any] struct {
+	Values  map[string]T             // sources that succeeded
+	Errors  map[string]error         // sources that failed or timed out
+	Latency map[string]time.Duration // how long each source took
+
+	// Canceled are the sources stopped because a required source failed,
+	// they didn't fail themselves so they are not in Errors
+	Canceled []string
+}
+
+// RequiredError is returned by Run when a required source fails
+type RequiredError struct {
+	Source string
+	Err    error
+}
+
+func (e *RequiredError) Error() string {
+	return fmt.Sprintf("fanout: required source %q failed: %v", e.Source, e.Err)
+}
+
+func (e *RequiredError) Unwrap() error {
+	return e.Err
+}
+
+type outcome[T any] struct {
+	name    string
+	value   T
+	err     error
+	latency time.Duration
+}
+
+// Run starts every source in its own goroutine and waits for all of them
+//
+// The result always has the partial values, the error map and latencies.
+// When a required source fails the other sources are canceled and the
+// error is a *RequiredError for that first failure, the sources that were
+// canceled because of it end up in Result.Canceled.
+func Run[T any](ctx context.Context, sources ...Source[T]) (Result[T], error) {
+	res := Result[T]{
+		Values:  make(map[string]T, len(sources)),
+		Errors:  make(map[string]error),
+		Latency: make(map[string]time.Duration, len(sources)),
+	}
+
+	seen := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		if s.Fetch == nil {
+			return res, fmt.Errorf("fanout: source %q has no Fetch func", s.Name)
+		}
+		if seen[s.Name] {
+			return res, fmt.Errorf("fanout: duplicate source %q", s.Name)
+		}
+		seen[s.Name] = true
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// buffered, so every goroutine can send its outcome without waiting for us
+	outcomes := make(chan outcome[T], len(sources))
+
+	for _, s := range sources {
+		go func(s Source[T]) {
+			outcomes <- runSource(ctx, s)
+		}(s)
+	}
+
+	var failed *RequiredError
+	for range sources {
+		o := <-outcomes
+		res.Latency[o.name] = o.latency
+
+		if o.err == nil {
+			res.Values[o.name] = o.value
+			continue
+		}
+
+		// our cancel after the first failure, not a failure of this source;
+		// a canceled parent is still an error of every source
+		if failed != nil && parent.Err() == nil && errors.Is(o.err, context.Canceled) {
+			res.Canceled = append(res.Canceled, o.name)
+			continue
+		}
+
+		res.Errors[o.name] = o.err
+		if failed == nil && isRequired(sources, o.name) {
+			failed = &RequiredError{Source: o.name, Err: o.err}
+			cancel(failed) // no reason to wait for the rest, the call failed anyway
+		}
+	}
+
+	if failed != nil {
+		return res, failed
+	}
+	return res, nil
+}
+
+func runSource[T any](ctx context.Context, s Source[T]) outcome[T] {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+
+	// Fetch may ignore the context (like fetchdata with its time.Sleep),
+	// so it runs in one more goroutine and we stop waiting when ctx is done
+	done := make(chan outcome[T], 1)
+	go func() {
+		var v T
+		err := panics.Call(func() (err error) {
+			v, err = s.Fetch(ctx)
+			return err
+		})
+		done <- outcome[T]{value: v, err: err} // a panic fails the source, not the program
+	}()
+
+	var o outcome[T]
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = ctx.Err()
+	}
+
+	o.name = s.Name
+	o.latency = time.Since(start)
+	return o
+}
+
+func isRequired[T any](sources []Source[T], name string) bool {
+	for _, s := range sources {
+		if s.Name == name {
+			return s.Required
+		}
+	}
+	return false
+}
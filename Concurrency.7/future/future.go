@@ -0,0 +1,211 @@
+// Package future is a value that will be there later
+//
+// Async starts a function in a goroutine and returns a *Future at once, Await
+// waits for the result. It's the goroutine + channel of one value pattern
+// from httpCallToApi, with the error, the panic and the cancellation handled
+// once here instead of in every caller.
+//
+// All, AllSettled, Any and Race combine futures into a new one, and cancel
+// the work that is not needed anymore (the rest of All after an error, the
+// losers of Any and Race).
+package future
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"sync"
+)
+
+// Future is the result of a computation that may not be finished yet
+type Future[T any] struct {
+	done   chan struct{}
+	once   sync.Once
+	val    T
+	err    error
+	cancel context.CancelFunc // cancels the work, nil for a Promise
+}
+
+// Async runs fn in a new goroutine, canceling ctx or calling Cancel cancels
+// the context fn gets. A panic in fn is the error of the future (*panics.PanicError).
+func Async[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) *Future[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &Future[T]{done: make(chan struct{}), cancel: cancel}
+
+	go func() {
+		defer cancel() // fn is done, release the context
+		var v T
+		err := panics.Call(func() (err error) {
+			v, err = fn(ctx)
+			return err
+		})
+		f.settle(v, err)
+	}()
+	return f
+}
+
+// Await waits for the result or for ctx. ctx only limits the waiting:
+// the work goes on, call Cancel to stop it.
+func (f *Future[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Done is closed when the result is there
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel cancels the context of the work, the future settles when the work returns
+func (f *Future[T]) Cancel() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+}
+
+// settle keeps the first result only
+func (f *Future[T]) settle(v T, err error) {
+	f.once.Do(func() {
+		f.val, f.err = v, err
+		close(f.done)
+	})
+}
+
+// Promise is a future that is settled by hand, for results that come
+// from a callback or another goroutine instead of a function
+type Promise[T any] struct {
+	f *Future[T]
+}
+
+func NewPromise[T any]() *Promise[T] {
+	return &Promise[T]{f: &Future[T]{done: make(chan struct{})}}
+}
+
+// Future returns the future of the promise
+func (p *Promise[T]) Future() *Future[T] { return p.f }
+
+// Resolve settles the future with v, later Resolve and Reject calls are ignored
+func (p *Promise[T]) Resolve(v T) { p.f.settle(v, nil) }
+
+// Reject settles the future with err
+func (p *Promise[T]) Reject(err error) {
+	var zero T
+	p.f.settle(zero, err)
+}
+
+// Result is the outcome of one future in AllSettled
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// All is the values of all futures in their order, or the first error;
+// after an error the other futures are canceled
+func All[T any](ctx context.Context, fs ...*Future[T]) *Future[[]T] {
+	return Async(ctx, func(ctx context.Context) ([]T, error) {
+		ch := settled(ctx, fs)
+		for range fs {
+			select {
+			case i := <-ch:
+				if err := fs[i].err; err != nil {
+					cancelAll(fs)
+					return nil, err
+				}
+			case <-ctx.Done():
+				cancelAll(fs)
+				return nil, ctx.Err()
+			}
+		}
+
+		values := make([]T, len(fs))
+		for i, f := range fs {
+			values[i] = f.val
+		}
+		return values, nil
+	})
+}
+
+// AllSettled waits for all futures and never fails, unless ctx is done
+func AllSettled[T any](ctx context.Context, fs ...*Future[T]) *Future[[]Result[T]] {
+	return Async(ctx, func(ctx context.Context) ([]Result[T], error) {
+		results := make([]Result[T], len(fs))
+		for i, f := range fs {
+			v, err := f.Await(ctx)
+			if ctx.Err() != nil {
+				cancelAll(fs)
+				return nil, ctx.Err()
+			}
+			results[i] = Result[T]{Value: v, Err: err}
+		}
+		return results, nil
+	})
+}
+
+// Any is the first value without an error, the others are canceled then.
+// If all futures fail the error has all their errors.
+func Any[T any](ctx context.Context, fs ...*Future[T]) *Future[T] {
+	return Async(ctx, func(ctx context.Context) (T, error) {
+		var zero T
+		errs := make([]error, 0, len(fs))
+		ch := settled(ctx, fs)
+		for range fs {
+			select {
+			case i := <-ch:
+				if fs[i].err == nil {
+					cancelAll(fs)
+					return fs[i].val, nil
+				}
+				errs = append(errs, fs[i].err)
+			case <-ctx.Done():
+				cancelAll(fs)
+				return zero, ctx.Err()
+			}
+		}
+		return zero, errors.Join(append([]error{ErrAllFailed}, errs...)...)
+	})
+}
+
+// ErrAllFailed is in the error of Any when no future had a value
+var ErrAllFailed = errors.New("future: all futures failed")
+
+// Race is the first future that settles, value or error, the others are canceled
+func Race[T any](ctx context.Context, fs ...*Future[T]) *Future[T] {
+	return Async(ctx, func(ctx context.Context) (T, error) {
+		defer cancelAll(fs)
+
+		select {
+		case i := <-settled(ctx, fs):
+			return fs[i].val, fs[i].err
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	})
+}
+
+// settled sends the index of every future when it settles, in that order.
+// The watchers stop with ctx, the combinators' ctx ends when they return.
+func settled[T any](ctx context.Context, fs []*Future[T]) <-chan int {
+	ch := make(chan int, len(fs)) // buffered, the watchers never block
+	for i, f := range fs {
+		go func() {
+			select {
+			case <-f.done:
+				ch <- i
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ch
+}
+
+func cancelAll[T any](fs []*Future[T]) {
+	for _, f := range fs {
+		f.Cancel()
+	}
+}
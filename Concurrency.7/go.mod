@@ -2,4 +2,4 @@ module conc
 
 go 1.22.3
 
-require golang.org/x/net v0.26.0 // indirect
+require golang.org/x/net v0.26.0
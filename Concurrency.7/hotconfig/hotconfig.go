@@ -0,0 +1,277 @@
+// Package hotconfig keeps a config that can be replaced while the program runs
+//
+// Readers call Load on every use, it's one atomic load without locks, so
+// reading the config in a hot path is fine. A new version is validated
+// before it's swapped in, a bad file never replaces a good config.
+package hotconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigStore holds the current *T, create it with New
+type ConfigStore[T any] struct {
+	current  atomic.Pointer[T]
+	defaults []byte // json, so every Decode starts from a fresh copy (maps and slices too)
+	validate func(*T) error
+
+	mu   sync.Mutex // one writer at a time, protects subs
+	subs map[chan *T]struct{}
+}
+
+// New creates a store with the defaults as the first version,
+// validate may be nil
+func New[T any](defaults T, validate func(*T) error) (*ConfigStore[T], error) {
+	data, err := json.Marshal(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("hotconfig: defaults: %w", err)
+	}
+
+	s := &ConfigStore[T]{
+		defaults: data,
+		validate: validate,
+		subs:     make(map[chan *T]struct{}),
+	}
+
+	first := defaults
+	if err := s.check(&first); err != nil {
+		return nil, fmt.Errorf("hotconfig: defaults: %w", err)
+	}
+	s.current.Store(&first)
+	return s, nil
+}
+
+// Load returns the current config, it's shared by everybody so don't modify it
+func (s *ConfigStore[T]) Load() *T {
+	return s.current.Load()
+}
+
+// Store validates v and makes it the current config, on error nothing changes
+func (s *ConfigStore[T]) Store(v *T) error {
+	if err := s.check(v); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current.Store(v)
+	for ch := range s.subs {
+		notify(ch, v)
+	}
+	return nil
+}
+
+// Decode reads a json version, fields missing in data keep their defaults
+// and unknown fields are an error (usually a typo in the file).
+// A map in data replaces the default map: json.Unmarshal would merge them
+// and a default entry could never be removed through the file.
+func (s *ConfigStore[T]) Decode(data []byte) error {
+	var v T
+	if err := json.Unmarshal(s.defaults, &v); err != nil {
+		return fmt.Errorf("hotconfig: defaults: %w", err)
+	}
+	clearMaps(reflect.ValueOf(&v).Elem(), data)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("hotconfig: %w", err)
+	}
+	return s.Store(&v)
+}
+
+// LoadFile reads the json file and stores it
+func (s *ConfigStore[T]) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("hotconfig: %w", err)
+	}
+	if err := s.Decode(data); err != nil {
+		return fmt.Errorf("%w (%s)", err, path)
+	}
+	return nil
+}
+
+// Watch checks the file every interval and reloads it when it changes,
+// it blocks until ctx is done. Bad versions go to onError and the old
+// config stays, a missing file is reported the same way.
+func (s *ConfigStore[T]) Watch(ctx context.Context, path string, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []byte // content of the last version we tried, good or bad
+	if data, err := os.ReadFile(path); err == nil {
+		last = data
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if last != nil && onError != nil {
+				onError(fmt.Errorf("hotconfig: %w", err))
+			}
+			last = nil
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue // nothing changed (or the same bad version we already reported)
+		}
+		last = data
+
+		if err := s.Decode(data); err != nil && onError != nil {
+			onError(fmt.Errorf("%w (%s), keeping the old config", err, path))
+		}
+	}
+}
+
+// Subscribe returns a channel that gets every new version
+// a slow reader only misses versions in between, it always gets the latest
+func (s *ConfigStore[T]) Subscribe() (<-chan *T, func()) {
+	ch := make(chan *T, 1)
+
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, ch)
+			s.mu.Unlock()
+		})
+	}
+	return ch, unsubscribe
+}
+
+func (s *ConfigStore[T]) check(v *T) error {
+	if s.validate == nil {
+		return nil
+	}
+	if err := s.validate(v); err != nil {
+		return fmt.Errorf("hotconfig: invalid config: %w", err)
+	}
+	return nil
+}
+
+// notify replaces an unread old version with the new one, s.mu is held
+func notify[T any](ch chan *T, v *T) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- v
+}
+
+// Duration is a time.Duration written as "5s" or "1m30s" in json
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// D is the time.Duration
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+// clearMaps sets to nil every map field of v that the json object in data
+// has, json.Unmarshal then fills a new map with the entries of data only.
+// Structs are walked down as far as data goes, a bad data is left for the
+// decoder to report.
+func clearMaps(v reflect.Value, data []byte) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return // nothing to merge into
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return
+	}
+
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			clearMaps(v.Field(i), data) // embedded fields are on the same level
+			continue
+		}
+
+		raw, ok := lookup(fields, jsonName(f))
+		if !ok {
+			continue
+		}
+		switch fv := v.Field(i); fv.Kind() {
+		case reflect.Map:
+			fv.SetZero()
+		case reflect.Struct, reflect.Pointer:
+			clearMaps(fv, raw)
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+// lookup finds the key like encoding/json does: exact match first, then
+// any case
+func lookup(fields map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if name == "" {
+		return nil, false
+	}
+	if raw, ok := fields[name]; ok {
+		return raw, true
+	}
+	for k, raw := range fields {
+		if strings.EqualFold(k, name) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,223 @@
+// Package lease makes sure only one copy of a program does the work on a host
+//
+// The leader holds an exclusive flock on a file and writes its pid and a
+// heartbeat time into it. The others are standbys: Acquire waits until the
+// lock is free, and the kernel frees it the moment the leader's process dies,
+// however it died, so there is no stale lease to clean up.
+//
+// Leadership can still be lost while the process lives: the lock file was
+// deleted or replaced (the next instance would lock the new file, two
+// leaders) or the heartbeat can't be written. Then the context of the Lease
+// is canceled with ErrLost and the work must stop.
+//
+// A leader that is stopped but not dead (SIGSTOP, a debugger) keeps the lock,
+// the heartbeat in the file is how a human sees it's stuck.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrHeld is returned by TryAcquire when another process is the leader
+	ErrHeld = errors.New("lease: held by another process")
+
+	// ErrLost is the cause of Lease.Context when leadership was lost
+	ErrLost = errors.New("lease: lost")
+)
+
+// Holder is what the leader writes into the lock file
+type Holder struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Acquired  time.Time `json:"acquired"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// ReadHolder reads the lock file, it doesn't tell whether the holder is alive:
+// an old heartbeat means a stuck (or dead and never replaced) leader
+func ReadHolder(path string) (Holder, error) {
+	var h Holder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	if len(data) == 0 {
+		return h, fmt.Errorf("lease: %s has no holder", path)
+	}
+	return h, json.Unmarshal(data, &h)
+}
+
+// Lease is the leadership, it's held until Release or until it's lost
+type Lease struct {
+	path     string
+	f        *os.File
+	interval time.Duration
+	holder   Holder
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	once sync.Once
+	done chan struct{} // closed when the heartbeat goroutine returned
+	err  error         // of Release
+}
+
+// DefaultInterval is the heartbeat and the standby poll interval when 0 is given
+const DefaultInterval = time.Second
+
+// Acquire waits until this process is the leader or ctx is done, checking
+// every interval. ctx is also the parent of the Lease's context.
+func Acquire(ctx context.Context, path string, interval time.Duration) (*Lease, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	// flock can block, but a blocked flock can't be canceled; polling can
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		l, err := tryAcquire(ctx, path, interval)
+		if !errors.Is(err, ErrHeld) {
+			return l, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TryAcquire is Acquire without waiting, ErrHeld if another process leads
+func TryAcquire(ctx context.Context, path string, interval time.Duration) (*Lease, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return tryAcquire(ctx, path, interval)
+}
+
+func tryAcquire(ctx context.Context, path string, interval time.Duration) (*Lease, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := lockFile(f)
+	if err != nil || !locked {
+		f.Close()
+		if err == nil {
+			err = ErrHeld
+		}
+		return nil, err
+	}
+
+	// the old leader may have deleted the file between our open and our
+	// lock, then we locked a file nobody else will ever look at
+	if !samePath(f, path) {
+		f.Close()
+		return nil, ErrHeld
+	}
+
+	host, _ := os.Hostname()
+	now := time.Now()
+	l := &Lease{
+		path:     path,
+		f:        f,
+		interval: interval,
+		holder:   Holder{PID: os.Getpid(), Host: host, Acquired: now, Heartbeat: now},
+		done:     make(chan struct{}),
+	}
+	if err := l.write(l.holder); err != nil {
+		f.Close() // closing the file releases the lock
+		return nil, err
+	}
+
+	l.ctx, l.cancel = context.WithCancelCause(ctx)
+	go l.heartbeat()
+	return l, nil
+}
+
+// Context is canceled when the lease is lost (context.Cause is ErrLost with
+// the reason), released, or the context given to Acquire is done. The lock
+// is held until Release in every case, call it when the work stopped.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Holder is what this lease wrote into the file at Acquire
+func (l *Lease) Holder() Holder {
+	return l.holder
+}
+
+// Release gives up the leadership, a standby can take it right away.
+// The file stays: deleting it would let two processes lock two files.
+func (l *Lease) Release() error {
+	l.once.Do(func() {
+		l.cancel(context.Canceled)
+		<-l.done // no heartbeat writes after this
+
+		l.f.Truncate(0) // the next leader writes its own holder, an empty file says nobody leads
+		l.err = unlockFile(l.f)
+		if err := l.f.Close(); l.err == nil {
+			l.err = err
+		}
+	})
+	return l.err
+}
+
+func (l *Lease) heartbeat() {
+	defer close(l.done)
+
+	h := l.holder // ours, Holder reads l.holder at the same time
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !samePath(l.f, l.path) {
+				l.cancel(fmt.Errorf("%w: %s was deleted or replaced", ErrLost, l.path))
+				return
+			}
+			h.Heartbeat = now
+			if err := l.write(h); err != nil {
+				l.cancel(fmt.Errorf("%w: heartbeat: %v", ErrLost, err))
+				return
+			}
+		}
+	}
+}
+
+// write replaces the content of the file with h
+func (l *Lease) write(h Holder) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	// write first and cut the rest after, ReadHolder never sees an empty file
+	if _, err := l.f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return l.f.Truncate(int64(len(data)))
+}
+
+// samePath says whether path still names the file we have open
+func samePath(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(a, b)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package lease
+
+import (
+	"errors"
+	"os"
+)
+
+// only linux has the flock we rely on here, Acquire fails elsewhere
+func lockFile(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func unlockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -1,15 +1,53 @@
 package main
 
 import (
+	"conc/actor"
+	"conc/admission"
+	"conc/balancer"
+	"conc/dag"
+	"conc/deadlock"
+	"conc/errgroup"
+	"conc/fanout"
+	"conc/future"
+	"conc/hotconfig"
+	"conc/lease"
+	"conc/markdown"
+	"conc/panics"
+	"conc/parallel"
+	"conc/queue"
+	"conc/requestlog"
+	"conc/runloop"
+	"conc/scheduler"
+	"conc/scope"
+	"conc/semaphore"
+	"conc/singleflight"
+	"conc/spsc"
+	"conc/timingwheel"
+	"conc/wordcount"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/websocket"
 )
 
 /********
@@ -36,6 +74,8 @@ func main() {
 	// concurrency
 	fmt.Println("concurrency")
 
+	MakeExampleFanOut() // all four fetch funcs at the same time, ~5 seconds instead of 20
+
 	// go func() { // anonymous function
 	// 	// go func can never be executed
 	// 	start := time.Now()
@@ -204,26 +244,59 @@ func main() {
 
 	MakeExampleConcurrency()
 
+	MakeExampleActor()
+
 	// MakeExampleConcurrency2()
 
 	MakeExample()
 
+	MakeExampleRing() // the same with a lock-free ring, and a benchmark against channels
+
+	MakeExampleBlockingQueue() // and with a queue that can peek, drain and close under a blocked sender
+
 	MakeChannel()
 
 	MakeExampleWaitGroup()
 
 	MakeExampleWaitGroup2()
 
+	MakeExampleDAG()
+
 	MakeExampleMutex()
 	MakeExampleWithoutMutex()
 
+	MakeExampleSemaphore()
+	MakeExampleErrGroup()
+
+	MakeExampleSafeGo()
+
+	MakeExampleTimingWheel()
+
+	MakeExampleLoadBalancer()
+
+	MakeExampleChat()
+
+	MakeExampleWordCount()
+
+	MakeExampleParallel()
+
+	MakeExampleDeadlock()
+
 	MakeExampleAtomic()
 
 	MakeExampleLockFree()
 
 	MakeExampleContext()
 
-	MakeParserContext()
+	MakeExampleScope()
+
+	MakeExampleFuture()
+
+	MakeExampleSingleflight()
+
+	MakeExampleScheduler()
+
+	MakeParserContext(FormatText) // or FormatJSON, FormatMarkdown
 
 }
 
@@ -254,6 +327,45 @@ func fetchapi() {
 
 }
 
+// fan-out: start every fetch in its own goroutine and collect results in one place
+// each source has its own timeout and says if the whole call fails without it
+
+func MakeExampleFanOut() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleFanOut")
+
+	// wrap the old functions, they don't take a context and return nothing
+	source := func(name string, fetch func(), timeout time.Duration, required bool) fanout.Source[string] {
+		return fanout.Source[string]{
+			Name:     name,
+			Timeout:  timeout,
+			Required: required,
+			Fetch: func(ctx context.Context) (string, error) {
+				fetch()
+				return name + " ok", nil
+			},
+		}
+	}
+
+	start := time.Now()
+
+	res, err := fanout.Run(context.Background(),
+		source("data", fetchdata, 6*time.Second, true),
+		source("db", fetchdb, 6*time.Second, true),
+		source("cache", fetchcache, time.Second, false), // optional, it will time out and we still get the rest
+		source("api", fetchapi, 6*time.Second, true),
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for name, latency := range res.Latency {
+		fmt.Printf("%s: value=%q err=%v took %v\n", name, res.Values[name], res.Errors[name], latency.Round(time.Millisecond))
+	}
+	fmt.Println("fan-out took: ", time.Since(start))
+}
+
 // best practice with goroutine
 //goroutines are usually triggered by a closure wrapping the business logic
 
@@ -322,6 +434,11 @@ func (s *Server) ServeLoop() {
 
 		*/
 		case <-s.ch: // receive from ch channel ?
+			// select picks at random when both are ready, handle what was
+			// sent before Stop or it's lost
+			for len(s.msgch) > 0 {
+				s.HandleMessage(<-s.msgch)
+			}
 			fmt.Println("SERVER STOPPING")
 			return // stop server
 		//if no receive from  ch channel
@@ -359,20 +476,86 @@ func MakeExampleConcurrency() {
 
 	server := NewServer()
 
-	server.ServeHTTP("GET", "/")
+	// the group runs the loop (ServeHTTP would start it with go and forget it)
+	// and stops it once the client is done, there is no sleep that guesses
+	// how long the message takes
+	var g runloop.Group
+	g.Add("server", func(context.Context) error {
+		fmt.Println("SERVER STARTING")
+		server.ServeLoop()
+		return nil
+	}, func(ctx context.Context) error {
+		select {
+		case server.ch <- struct{}{}: // Stop, but not longer than the deadline
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	g.Add("client", func(context.Context) error {
+		server.SendMessage("message from server")
+		return nil // a run that returns ends the group
+	}, nil)
+	runloop.Exit(g.Run(context.Background()))
+}
+
+// Server is an actor: a goroutine, a mailbox and a select loop
+// but if HandleMessage panics the whole program dies
+// the actor package adds a supervisor that restarts it
+
+func MakeExampleActor() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleActor")
+
+	sup := &actor.Supervisor{
+		Strategy:    actor.OneForOne,
+		MaxRestarts: 3,
+		Within:      time.Second,
+		OnRestart: func(name string, reason error) {
+			fmt.Println("restarting", name)
+		},
+	}
+
+	// the state lives in the closure, so only the actor goroutine touches it
+	counter, err := actor.Spawn(sup, "counter", 8, func() actor.Behavior[string, int] {
+		count := 0 // starts from zero again after a restart
+		return func(ctx context.Context, msg string) (int, error) {
+			switch msg {
+			case "inc":
+				count++
+			case "boom":
+				panic("bad message")
+			}
+			return count, nil
+		}
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	counter.Tell(ctx, "inc") // fire and forget
+	counter.Tell(ctx, "inc")
+	fmt.Println(counter.Ask(ctx, "get")) // 2 <nil>
+
+	_, err = counter.Ask(ctx, "boom") // the caller gets an error, the program keeps running
+	fmt.Println("boom:", err)         // the stack is in the *panics.PanicError inside
 
-	server.SendMessage("message from server")
-	time.Sleep(time.Second) // to give the time for goroutine to process the message before program termination.
-	// This helps to make sure that the message is processed before the main program terminates.
+	fmt.Println(counter.Ask(ctx, "inc")) // 1 <nil>, a fresh actor
 
+	fmt.Println("stop:", sup.Stop(ctx))
+	_, err = counter.Ask(ctx, "get")
+	fmt.Println(err)
 }
 
 // another example using goroutine channels and select
 
 type RealServ struct {
-	taskCh chan string // taskCh - channel for task transfer
-
-	resultCh chan string // resultCh - the channel for transmitting results.
+	taskCh chan taskRequest // taskCh - channel for task transfer, the result comes back on taskRequest.reply
 
 	closeCh chan struct{} // closeCh - channel for closing the server
 
@@ -382,49 +565,124 @@ type RealServ struct {
 		you don't need to transfer data but just need the fact of completion.
 
 	*/
+
+	doneCh chan struct{} // doneCh is closed when the server stops, long requests (event streams) end on it
+
+	tasksMu sync.Mutex
+	tasks   map[string]*trackedTask // tasks created with POST /tasks, see events.go
+	nextID  int64
+
+	config *hotconfig.ConfigStore[ServerConfig] // timeouts, workers and limits, see config.go
+
+	timers *timingwheel.Wheel // request timeouts, cheaper than a runtime timer per request
+
+	limiter *admission.Limiter // quotas per client, read from config
+
+	logger *slog.Logger // logs with a request context get its request id, see SetLogFormat
+
+	srv *http.Server // Start serves on it, Shutdown stops it
+}
+
+// taskRequest carries the context of the http request along with the task,
+// so the logs of TaskHandler have the request id
+//
+// every request has its own reply channel: with several workers a shared
+// result channel would give one client the result of another
+type taskRequest struct {
+	ctx   context.Context
+	task  string
+	reply chan string // buffered, the worker never waits for a client that timed out
 }
 
 // create constuctor for server
 
 func NewRealServer() *RealServ {
-	return &RealServ{
+	config, err := hotconfig.New(defaultServerConfig(), (*ServerConfig).Validate)
+	if err != nil {
+		panic(err) // the defaults are ours, they must be valid
+	}
+
+	r := &RealServ{
 		/*
 			Channels are created using the make function,
 			which is used to initialize slices, maps, and channels in Go.
 
 		*/
-		taskCh:   make(chan string), // we can't use only taskch chan string because is nil
-		resultCh: make(chan string),
-		closeCh:  make(chan struct{}),
+		taskCh:  make(chan taskRequest), // we can't use only taskch chan string because is nil
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+
+		tasks: make(map[string]*trackedTask),
+
+		config: config,
+		timers: timingwheel.New(10 * time.Millisecond),
+
+		logger: slog.New(requestlog.Handler{Handler: slog.NewTextHandler(os.Stderr, nil)}),
+
+		srv: &http.Server{Addr: ":8080"},
+	}
+	r.limiter = admission.New(func() *admission.Config { return &r.config.Load().Admission })
+	return r
+}
+
+// SetLogFormat switches the logs to text or json, call it before Start
+func (r *RealServ) SetLogFormat(format requestlog.Format) error {
+	logger, err := requestlog.NewLogger(os.Stderr, format, slog.LevelInfo)
+	if err != nil {
+		return err
 	}
+	r.logger = logger
+	return nil
 }
 
-func (r *RealServ) Start() { // start our server and handler request
+func (r *RealServ) Start() error { // start our server and handler request, returns after Shutdown
 	// start handler in another goroutine
 	go r.TaskHandler()
 
-	http.HandleFunc("/", r.HandleRequest)
-	http.ListenAndServe(":8080", nil)
+	// one client can't take all workers: 429 over its quota, see admission
+	http.Handle("/", r.limiter.Middleware(http.HandlerFunc(r.HandleRequest)))
+	http.Handle("POST /tasks", r.limiter.Middleware(http.HandlerFunc(r.HandleCreateTask)))
+	// a websocket connection holds its slot until it's closed
+	http.Handle("GET /ws/tasks", r.limiter.Middleware(websocket.Handler(r.HandleTaskSocket)))
+	http.HandleFunc("GET /tasks/{id}/events", r.HandleTaskEvents) // server-sent events
+	http.HandleFunc("GET /healthz", r.HandleHealth)               // for load balancers, see balancer
+	// every request gets an X-Request-ID and the id goes into the context
+	r.srv.Handler = requestlog.Middleware(r.logger, http.DefaultServeMux)
+	if err := r.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err // the port is taken, for example
+	}
+	return nil
 }
 
 func (r *RealServ) TaskHandler() { // handler for task
-	for { // loop always waiting for tasks or signal for close
-		select {
-		case task := <-r.taskCh:
-			// simulation another process
-			time.Sleep(time.Second * 2)
-			result := "Processed: " + task
+	workersDone := make(chan struct{})
+	go func() {
+		defer close(workersDone)
+		r.runWorkers() // the workers read taskCh, see config.go
+	}()
 
-			r.resultCh <- result
+	<-r.closeCh // wait for signal for close
+	// end task handler
+	close(r.doneCh) // tell workers, event streams and running tasks that we are done
+	<-workersDone
+	r.timers.Stop()
+	close(r.closeCh)
+	// taskCh stays open: HandleRequest may still be sending to it and a send
+	// on a closed channel panics, it stops on doneCh instead
+}
 
-		case <-r.closeCh:
-			// end task handler
-			close(r.resultCh)
-			close(r.closeCh)
-			close(r.taskCh)
-			return
+// process does the work of one task, the websocket handler uses it too
+func (r *RealServ) process(ctx context.Context, task string) (string, error) {
+	start := time.Now()
+	r.logger.InfoContext(ctx, "task started", "task", task) // request_id is added by the handler
 
-		}
+	select {
+	case <-time.After(r.config.Load().TaskDuration.D()): // simulation another process
+		r.logger.InfoContext(ctx, "task done", "task", task, "duration", time.Since(start))
+		return "Processed: " + task, nil
+	case <-ctx.Done():
+		r.logger.WarnContext(ctx, "task canceled", "task", task, "err", ctx.Err())
+		return "", ctx.Err()
 	}
 }
 
@@ -438,40 +696,141 @@ func (r *RealServ) HandleRequest(w http.ResponseWriter, s *http.Request) {
 
 	}
 
-	r.taskCh <- task // add task to channel
+	// read the config once, a reload doesn't change a running request
+	ctx, cancel := r.timers.WithTimeout(s.Context(), r.config.Load().RequestTimeout.D())
+	defer cancel()
+	req := taskRequest{ctx: s.Context(), task: task, reply: make(chan string, 1)}
 
 	// Send a task to the taskCh channel so that another goroutine can process it.
+	select {
+	case r.taskCh <- req: // add task to channel
+	case <-ctx.Done():
+		http.Error(w, "timeout", http.StatusRequestTimeout) // all workers are busy (or the client left)
+		return
+	case <-r.doneCh:
+		http.Error(w, "server is stopping", http.StatusServiceUnavailable)
+		return
+	}
 
 	// read result
 	select {
-	//We use the select statement to expect either a result from the resultCh channel or a timeout after 5 seconds.
-	case result := <-r.resultCh:
+	//We use the select statement to expect either a result from the reply channel or a timeout (request_timeout in the config).
+	case result := <-req.reply:
 		fmt.Fprintf(w, "Result : %s\n", result)
-	case <-time.After(time.Second * 5):
+	case <-ctx.Done():
 		http.Error(w, "timeout", http.StatusRequestTimeout)
-		// If 5 seconds have passed and no result is received, we return an error with the code 504 Gateway Timeout.
+		// If the time is over and no result is received, we return an error with the code 408 Request Timeout.
 	}
 
 }
 
+// HandleHealth says 200 while the server takes tasks and 503 when it's stopping,
+// so a load balancer stops sending requests before we are gone
+func (r *RealServ) HandleHealth(w http.ResponseWriter, s *http.Request) {
+	select {
+	case <-r.doneCh:
+		http.Error(w, "stopping", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
+
 func (r *RealServ) Stop() {
 	r.closeCh <- struct{}{}
 }
 
+// Shutdown is Stop and then the http server, before ctx is done. The order
+// matters: the event streams end on doneCh, the http server would wait for
+// them until the deadline.
+func (r *RealServ) Shutdown(ctx context.Context) error {
+	select {
+	case r.closeCh <- struct{}{}: // new requests get 503 from now on
+	case <-ctx.Done():
+		return ctx.Err() // TaskHandler doesn't run (Start wasn't called) or is stuck
+	}
+	return r.srv.Shutdown(ctx) // waits for the requests still running
+}
+
 func MakeExampleConcurrency2() {
 	fmt.Println("------")
 
 	fmt.Println("MakeExampleConcurrency2")
 	server := NewRealServer()
+	server.SetLogFormat(requestlog.FormatText) // or requestlog.FormatJSON
+
+	// one RealServ per host: a second copy waits as a standby instead of
+	// failing to bind :8080, and takes over when the leader's process dies.
+	// Ctrl-C stops the wait; once we lead the signals belong to the group,
+	// and the lease must not end with the signal context.
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	waiting, stopWaiting := context.WithCancel(context.Background())
+	unlink := context.AfterFunc(signals, stopWaiting)
+	work, release, err := acquireLeadership(waiting, filepath.Join(os.TempDir(), "realserv.lock"))
+	unlink()
+	stopSignals()
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("standby: stopped while waiting")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	go server.Start()
+	// settings come from realserv.json if it's there, edit it while the server runs
+	if err := server.WatchConfig(work, "realserv.json"); err != nil {
+		fmt.Println(err)
+		release()
+		return
+	}
 
-	fmt.Println("server started at port 8080")
+	// runs until Ctrl-C, then the server stops first and the lease last;
+	// a lost lease ends the group too, another copy may lead already
+	var g runloop.Group
+	g.Add("leadership", func(ctx context.Context) error {
+		select {
+		case <-work.Done():
+			return context.Cause(work) // lease.ErrLost, or Canceled by release
+		case <-ctx.Done():
+			return nil
+		}
+	}, func(context.Context) error { return release() })
+	g.Add("http", func(context.Context) error {
+		fmt.Println("server started at port 8080")
+		return server.Start()
+	}, server.Shutdown)
 
-	time.Sleep(time.Second * 5)
-	server.Stop()
+	err = g.Run(context.Background())
 	fmt.Println("server stopped")
+	runloop.Exit(err) // status 1 if something didn't stop cleanly
 	// to test use http://localhost:8080/task?task=your_task_here
+
+	// or watch the progress of a task:
+	// curl -X POST 'http://localhost:8080/tasks?task=your_task_here' -> {"id":"1"}
+	// curl -N http://localhost:8080/tasks/1/events
+}
+
+// acquireLeadership waits until this process holds the lease at path. work
+// is canceled when the lease is lost, release gives it up. Without flock
+// (not linux) there is no lease and every copy leads.
+func acquireLeadership(ctx context.Context, path string) (work context.Context, release func() error, err error) {
+	l, err := lease.TryAcquire(ctx, path, time.Second)
+	if errors.Is(err, lease.ErrHeld) {
+		if h, err := lease.ReadHolder(path); err == nil {
+			fmt.Printf("standby: pid %d leads since %s, waiting\n", h.PID, h.Acquired.Format(time.TimeOnly))
+		}
+		l, err = lease.Acquire(ctx, path, time.Second)
+	}
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, func() error { cancel(); return nil }, nil
+	case err != nil:
+		return nil, nil, err
+	}
+
+	fmt.Println("leader: pid", l.Holder().PID)
+	return l.Context(), l.Release, nil
 }
 
 /*Imagine that you have several workers (goroutines) who perform tasks
@@ -503,6 +862,72 @@ func MakeExample() {
 
 }
 
+// one producer and one consumer is the most common shape of MakeExample, for
+// it a ring with two atomic counters is faster than a channel
+// (go test -bench . ./spsc compares them)
+
+func MakeExampleRing() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleRing")
+	ring := spsc.New[int](4)
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			ring.Push(i) // waits when the ring is full, like a send on a full channel
+		}
+		ring.Close() // like close(ch)
+	}()
+
+	for {
+		v, ok := ring.Pop() // like v, ok := <-ch
+		if !ok {
+			break
+		}
+		fmt.Print(v, " ")
+	}
+	fmt.Println()
+}
+
+func MakeExampleBlockingQueue() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleBlockingQueue")
+	q := queue.New[string](2)
+
+	ctx := context.Background()
+	q.Put(ctx, "first")
+	q.Put(ctx, "second")
+	next, _ := q.Peek()
+	fmt.Println("len", q.Len(), "of", q.Cap(), "next:", next) // a channel can't show the next item
+
+	// the queue is full: Offer gives up after the timeout, Put after the context
+	fmt.Println("offer:", q.Offer("third", 10*time.Millisecond))
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	fmt.Println("put:", q.Put(tctx, "third"))
+	cancel()
+
+	fmt.Println("drained:", q.Drain())
+	v, err := q.Poll(10 * time.Millisecond)
+	fmt.Printf("poll: %q %v\n", v, err)
+
+	// Close wakes the blocked consumers, a closed channel does the same but a
+	// blocked producer on a closed channel would panic, here it gets ErrClosed
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.Take(ctx)
+			fmt.Println("consumer", i, "woke up:", err)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	wg.Wait()
+	fmt.Println("put after close:", errors.Is(q.Put(ctx, "late"), queue.ErrClosed))
+}
+
 func MakeChannel() { // unbeffered
 	ch := make(chan string) // 1
 
@@ -645,6 +1070,46 @@ Goroutine 2 unlocks, receives the ch1 close signal, and prints "2" (line 10).
 
 */
 
+// with more goroutines the channels get messy, a DAG does the same by names:
+// every task says which tasks must be done before it starts
+
+func MakeExampleDAG() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleDAG")
+
+	var g dag.Graph
+
+	say := func(s string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			fmt.Println(s)
+			return nil
+		}
+	}
+
+	// the same order as MakeExampleWaitGroup2, but without ch1 and ch2
+	g.Add(dag.Task{Name: "1", Run: say("1")})
+	g.Add(dag.Task{Name: "2", Deps: []string{"1"}, Run: say("2")})
+	g.Add(dag.Task{Name: "3", Deps: []string{"2"}, Run: say("3")})
+
+	// another branch that fails, "4" is skipped but "1", "2", "3" still run
+	g.Add(dag.Task{Name: "broken", Run: func(ctx context.Context) error {
+		return fmt.Errorf("something went wrong")
+	}})
+	g.Add(dag.Task{Name: "4", Deps: []string{"3", "broken"}, Run: say("4")})
+
+	report, err := g.Run(context.Background(), 2) // at most 2 tasks at the same time
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for _, t := range report {
+		fmt.Printf("%s: %s in %v\n", t.Name, t.Status, t.Duration)
+	}
+
+	fmt.Print(g.DOT(report)) // paste into graphviz to see the graph
+}
+
 // another way control goroutine using mutex
 
 type Count struct {
@@ -730,6 +1195,348 @@ func MakeExampleWithoutMutex() {
 	// in result of this function you will see the data race
 }
 
+// WaitGroup can't limit how many goroutines work at once and doesn't know about errors
+
+// semaphore: every goroutine takes a weight, at most 4 units are held at the same time
+
+func MakeExampleSemaphore() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleSemaphore")
+
+	sem := semaphore.NewWeighted(4)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+
+	for i := 1; i <= 6; i++ {
+		wg.Add(1)
+		go func(weight int64) {
+			defer wg.Done()
+
+			weight = weight%3 + 1 // heavy tasks take more of the semaphore
+
+			if err := sem.Acquire(ctx, weight); err != nil { // blocks until there is room
+				fmt.Println(err)
+				return
+			}
+			defer sem.Release(weight)
+
+			fmt.Println("working with weight", weight)
+			time.Sleep(100 * time.Millisecond)
+		}(int64(i))
+	}
+
+	wg.Wait()
+
+	fmt.Println("try acquire 5 of 4:", sem.TryAcquire(5)) // never blocks
+}
+
+// errgroup: like WaitGroup, but the first error cancels the others and comes back from Wait
+
+func MakeExampleErrGroup() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleErrGroup")
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(2) // at most 2 goroutines at the same time
+
+	for i := 1; i <= 5; i++ {
+		g.Go(func() error { // Go waits while 2 goroutines are running
+			if i == 3 {
+				return fmt.Errorf("task %d failed", i)
+			}
+
+			select {
+			case <-time.After(100 * time.Millisecond):
+				fmt.Println("task", i, "done")
+				return nil
+			case <-ctx.Done(): // canceled because task 3 failed
+				fmt.Println("task", i, "canceled")
+				return ctx.Err()
+			}
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		fmt.Println("first error:", err)
+	}
+}
+
+// a panic in a goroutine can't be recovered by the goroutine that started it,
+// so without a defer inside the goroutine (handlePunic in Functions.3) the
+// whole program dies. panics.SafeGo always has that defer.
+
+func MakeExampleSafeGo() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleSafeGo")
+
+	errs := make(chan error, 1)
+	ctx := panics.WithHandler(context.Background(), func(err error) { errs <- err })
+
+	panics.SafeGo(ctx, func(ctx context.Context) error {
+		var m map[string]int
+		m["boom"] = 1 // assignment to entry in nil map
+		return nil
+	})
+
+	err := <-errs
+	var p *panics.PanicError
+	if errors.As(err, &p) {
+		fmt.Println("recovered:", p.Value)                                   // the stack is in p.Stack
+		fmt.Println("is runtime error:", errors.As(err, new(runtime.Error))) // Unwrap gives the panic value
+	}
+
+	// the same for a pool of goroutines: the panic is the error of Wait
+	g, _ := errgroup.WithContext(context.Background())
+	g.SetLimit(2)
+	g.SetRecoverPanics(true)
+
+	for i := 1; i <= 3; i++ {
+		g.Go(func() error {
+			if i == 2 {
+				panic(fmt.Sprintf("task %d is broken", i))
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	fmt.Println("wait:", err)
+	if errors.As(err, &p) {
+		fmt.Println("stack:", len(p.Stack), "bytes") // the same *panics.PanicError as above
+	}
+}
+
+// a server with a timeout per request has as many timers as requests in flight.
+// timingwheel keeps them in slots by their deadline instead of one heap,
+// adding and stopping a timer costs the same with 10 or 100k others
+// (go test -bench . ./timingwheel compares it with time.AfterFunc)
+
+func MakeExampleTimingWheel() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleTimingWheel")
+
+	wheel := timingwheel.New(time.Millisecond) // a timer fires at most 1ms late
+	defer wheel.Stop()
+
+	fired := make(chan string, 2)
+	wheel.AfterFunc(20*time.Millisecond, func() { fired <- "20ms" })
+	t := wheel.AfterFunc(10*time.Millisecond, func() { fired <- "10ms" })
+	t.Reset(40 * time.Millisecond) // now it fires after the other one
+	fmt.Println(<-fired, <-fired)
+
+	ctx, cancel := wheel.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	fmt.Println(ctx.Err()) // context deadline exceeded
+}
+
+// several copies of the server behind one address: the balancer picks a
+// backend per request, ejects the ones that fail health checks and tries
+// a GET again on another backend if the first one didn't answer
+
+func MakeExampleLoadBalancer() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleLoadBalancer")
+
+	// three backends in the same process, httptest gives each a free port
+	var urls []string
+	names := make(map[string]string) // host:port -> name
+	var down [3]atomic.Bool          // a backend that is down answers nothing useful
+	for i := range 3 {
+		name := fmt.Sprintf("backend-%d", i)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, s *http.Request) {
+			if down[i].Load() {
+				// like a crashed process: close the connection without an answer
+				conn, _, _ := http.NewResponseController(w).Hijack()
+				conn.Close()
+				return
+			}
+			if s.URL.Path == "/healthz" {
+				fmt.Fprintln(w, "ok")
+				return
+			}
+			fmt.Fprint(w, name)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+		names[srv.Listener.Addr().String()] = name
+	}
+
+	get := func(h http.Handler, task string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?task="+task, nil))
+		if w.Code != http.StatusOK {
+			return strconv.Itoa(w.Code)
+		}
+		return w.Body.String()
+	}
+
+	// round-robin: every backend in turn
+	rr, _ := balancer.New(&balancer.RoundRobin{}, urls...)
+	for range 4 {
+		fmt.Print(get(rr, "a"), " ")
+	}
+	fmt.Println()
+
+	// consistent hashing: the same task always goes to the same backend
+	byTask, _ := balancer.New(balancer.ConsistentHash{Key: func(r *http.Request) string {
+		return r.URL.Query().Get("task")
+	}}, urls...)
+	for _, task := range []string{"build", "test", "build", "test"} {
+		fmt.Print(task, "->", get(byTask, task), " ")
+	}
+	fmt.Println()
+
+	// backend 0 goes down: the request is retried on another backend and
+	// the failure counts, health checks eject it for everybody
+	lb, _ := balancer.New(balancer.LeastOutstanding{}, urls...)
+	lb.HealthInterval = 20 * time.Millisecond
+	lb.OnChange = func(b *balancer.Backend, healthy bool) {
+		fmt.Println(names[b.URL.Host], "healthy:", healthy)
+	}
+
+	// the health checks run until the client is done
+	var g runloop.Group
+	g.Add("health checks", func(ctx context.Context) error {
+		lb.RunHealthChecks(ctx)
+		return nil
+	}, nil)
+	g.Add("client", func(context.Context) error {
+		down[0].Store(true)
+		fmt.Println("backend-0 down, got:", get(lb, "a")) // never the broken one
+		time.Sleep(100 * time.Millisecond)                // ejected by now
+
+		down[0].Store(false)
+		time.Sleep(100 * time.Millisecond) // two good checks bring it back
+		return nil
+	}, nil)
+	runloop.Exit(g.Run(context.Background()))
+
+	for _, b := range lb.Backends() {
+		fmt.Print(b.Healthy(), " ")
+	}
+	fmt.Println()
+}
+
+// WaitGroup, channels and context together on real files: a walker goroutine,
+// a pool of workers and results that come in while the others still work
+// the same code is a command: go run ./cmd/wordcount -top 10 .
+
+func MakeExampleWordCount() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleWordCount")
+
+	dir, err := os.MkdirTemp("", "wordcount")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.txt":        "the quick brown fox\njumps over the lazy dog\n",
+		"sub/b.txt":    "The dog sleeps.\nThe fox runs!\n",
+		"sub/c.bin":    "\x00\x01binary",
+		".git/ignored": "not counted",
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(text), 0o644)
+	}
+
+	var totals wordcount.Totals
+	for r := range wordcount.Count(context.Background(), dir, 2) {
+		totals.Add(r) // one goroutine reads the results, Totals needs no mutex
+	}
+	fmt.Printf("files %d, skipped %d, lines %d, words %d, bytes %d\n",
+		totals.Files, totals.Skipped, totals.Lines, totals.Words, totals.Bytes)
+	fmt.Println(totals.Top(3))
+
+	// a canceled context stops the walker and the workers, the channel closes
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := 0
+	for range wordcount.Count(ctx, dir, 2) {
+		n++
+	}
+	fmt.Println("results after cancel:", n)
+}
+
+// customSort in Functions.3 and Sum in Generics.6 use one goroutine.
+// parallel splits the slice between goroutines (at most GOMAXPROCS of them)
+// and does small slices with the plain loop, where goroutines cost more
+// than they save (go test -bench . ./parallel shows from which size)
+
+func MakeExampleParallel() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleParallel")
+
+	data := make([]int, 100_000)
+	for i := range data {
+		data[i] = rand.IntN(1000)
+	}
+
+	fmt.Println("sum:", parallel.Sum(data))
+
+	parallel.SortFunc(data, func(a, b int) int { return a - b }) // stable merge sort
+	fmt.Println("sorted:", slices.IsSorted(data))
+
+	prefix := []int{3, 1, 4, 1, 5, 9, 2, 6}
+	parallel.PrefixSum(prefix, parallel.Options{Threshold: 2}) // tiny threshold to split even this
+	fmt.Println("prefix sum:", prefix)
+
+	// op only has to be associative, concatenation keeps the order
+	words := []string{"go", "rou", "ti", "nes"}
+	fmt.Println(parallel.Reduce(words, "", func(a, b string) string { return a + b }, parallel.Options{Threshold: 1}))
+}
+
+// deadlock: goroutine 1 takes a then b, goroutine 2 takes b then a
+// if they run at the same time each one waits for the other forever
+// here they run one after another so nothing hangs, but the order is still wrong
+
+// run with *go run -tags lockdebug .* to see the report with both stacks
+
+func MakeExampleDeadlock() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleDeadlock")
+
+	var a, b deadlock.DebugMutex // drop-in for sync.Mutex
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.Lock()
+		b.Lock()
+		b.Unlock()
+		a.Unlock()
+	}()
+	wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.Lock()
+		a.Lock() // lock order inversion is reported here
+		a.Unlock()
+		b.Unlock()
+	}()
+	wg.Wait()
+
+	fmt.Println("lock order checked:", deadlock.Enabled)
+}
+
 // to check data race use *go run -race main.go*
 
 // go has alternative for mutex
@@ -947,6 +1754,263 @@ func MakeExampleContext() {
 	fmt.Printf("the response took %v:   %+v\n", time.Since(start), result)
 }
 
+// httpCallToApi returns after 50ms but its first goroutine sleeps 50 seconds
+// and then blocks forever on ch: nobody reads it anymore. With a scope the
+// function can't return before its goroutines did, so they must be cancelable.
+
+func httpCallToApiScoped(ctx context.Context, s string) (string, error) {
+	var result string
+	err := scope.Run(ctx, func(sc *scope.Scope) error {
+		results := make(chan string, 2) // buffered: the loser can still send and return
+
+		call := func(d time.Duration, res string) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				select {
+				case <-time.After(d): // imitation long api call to get data
+					results <- res
+					return nil
+				case <-ctx.Done():
+					return nil // canceled because the other one was faster
+				}
+			}
+		}
+		sc.Go(call(50*time.Second, s))
+		sc.Go(call(50*time.Millisecond, s+"goroutine"))
+
+		select {
+		case result = <-results:
+			sc.Cancel() // stop the slow one, Run waits for it
+			return nil
+		case <-sc.Context().Done():
+			return sc.Context().Err()
+		}
+	})
+	return result, err
+}
+
+func MakeExampleScope() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleScope")
+
+	before := runtime.NumGoroutine()
+	start := time.Now()
+	result, err := httpCallToApiScoped(context.Background(), "test-ctx")
+	fmt.Printf("%s %v in %v, goroutines left: %d\n",
+		result, err, time.Since(start).Round(10*time.Millisecond), runtime.NumGoroutine()-before)
+
+	// the first error (or panic) cancels the others and comes out of Run
+	err = scope.Run(context.Background(), func(s *scope.Scope) error {
+		for i := 1; i <= 3; i++ {
+			s.Go(func(ctx context.Context) error {
+				if i == 2 {
+					panic("worker 2 is broken")
+				}
+				<-ctx.Done() // the others wait until the scope is canceled
+				fmt.Println("worker", i, "canceled")
+				return nil
+			})
+		}
+		return nil
+	})
+	var p *panics.PanicError
+	if errors.As(err, &p) {
+		fmt.Println("panic came out of Run:", p.Value)
+	}
+
+	// Server.ServeLoop in a scope: the loop ends with the scope, like it ends
+	// with the runloop.Group in MakeExampleConcurrency
+	scope.Run(context.Background(), func(s *scope.Scope) error {
+		msgch := make(chan string)
+		s.Go(func(ctx context.Context) error {
+			for {
+				select {
+				case <-ctx.Done():
+					fmt.Println("SERVER STOPPING")
+					return nil
+				case msg := <-msgch:
+					fmt.Println(msg)
+				}
+			}
+		})
+
+		msgch <- "message from server"
+		s.Cancel()
+		return nil
+	})
+}
+
+// sleepCall is an imitation api call that can be canceled
+func sleepCall(d time.Duration, res string, err error) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(d):
+			return res, err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// httpCallToApi on futures: Race takes the first result and cancels the
+// slow call, nothing is left blocked on a channel
+func httpCallToApiFuture(ctx context.Context, s string) (string, error) {
+	return future.Race(ctx,
+		future.Async(ctx, sleepCall(50*time.Second, s, nil)),
+		future.Async(ctx, sleepCall(50*time.Millisecond, s+"goroutine", nil)),
+	).Await(ctx)
+}
+
+func MakeExampleFuture() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleFuture")
+
+	ctx := context.Background()
+	before := runtime.NumGoroutine()
+	start := time.Now()
+	result, err := httpCallToApiFuture(ctx, "test-ctx")
+	time.Sleep(10 * time.Millisecond) // the canceled call needs a moment to return
+	fmt.Printf("race: %s %v in %v, goroutines left: %d\n",
+		result, err, time.Since(start).Round(10*time.Millisecond), runtime.NumGoroutine()-before)
+
+	// All: every value in order, the first error cancels the rest
+	values, err := future.All(ctx,
+		future.Async(ctx, sleepCall(30*time.Millisecond, "a", nil)),
+		future.Async(ctx, sleepCall(10*time.Millisecond, "b", nil)),
+		future.Async(ctx, sleepCall(20*time.Millisecond, "c", nil)),
+	).Await(ctx)
+	fmt.Println("all:", values, err)
+
+	slow := future.Async(ctx, sleepCall(time.Second, "slow", nil))
+	_, err = future.All(ctx,
+		slow,
+		future.Async(ctx, sleepCall(10*time.Millisecond, "", errors.New("api is down"))),
+	).Await(ctx)
+	_, slowErr := slow.Await(ctx)
+	fmt.Println("all failed:", err, "- the slow one:", slowErr)
+
+	// Any: the first success, the errors only when there is none
+	value, err := future.Any(ctx,
+		future.Async(ctx, sleepCall(10*time.Millisecond, "", errors.New("mirror 1 is down"))),
+		future.Async(ctx, sleepCall(20*time.Millisecond, "from mirror 2", nil)),
+		future.Async(ctx, sleepCall(time.Second, "from mirror 3", nil)),
+	).Await(ctx)
+	fmt.Println("any:", value, err)
+
+	// AllSettled: every outcome, a panic is an error like the others
+	results, _ := future.AllSettled(ctx,
+		future.Async(ctx, sleepCall(10*time.Millisecond, "ok", nil)),
+		future.Async(ctx, func(ctx context.Context) (string, error) { panic("broken call") }),
+	).Await(ctx)
+	for i, r := range results {
+		var p *panics.PanicError
+		if errors.As(r.Err, &p) {
+			fmt.Println("settled", i, "panicked:", p.Value)
+			continue
+		}
+		fmt.Println("settled", i, r.Value, r.Err)
+	}
+
+	// a Promise is settled from outside, here from a callback
+	p := future.NewPromise[string]()
+	time.AfterFunc(10*time.Millisecond, func() { p.Resolve("callback fired") })
+	fmt.Println(p.Future().Await(ctx))
+}
+
+// singleflight: many goroutines ask the same slow api with the same key
+// the call runs once and everybody gets the same result
+
+func MakeExampleSingleflight() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleSingleflight")
+
+	group := &singleflight.Group[string, string]{TTL: time.Second} // keep the result for a second
+
+	var calls int64 // how many times we really called the api
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			result, shared, err := group.Do(ctx, "test-ctx", func(ctx context.Context) (string, error) {
+				atomic.AddInt64(&calls, 1)
+				return httpCallToApi(ctx, "test-ctx")
+			})
+			fmt.Println(result, shared, err)
+		}()
+	}
+
+	wg.Wait()
+	fmt.Println("api calls: ", atomic.LoadInt64(&calls)) // 1 instead of 10
+}
+
+// scheduler: periodic jobs without for { ...; time.Sleep() } loops
+// the context decides when everything stops
+
+func MakeExampleScheduler() {
+	fmt.Println("------")
+
+	fmt.Println("MakeExampleScheduler")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s := &scheduler.Scheduler{
+		OnError: func(job string, err error) {
+			fmt.Println("job", job, "failed:", err) // a panic is one line too, its stack is in (*panics.PanicError).Stack
+		},
+	}
+
+	s.Add(scheduler.Job{
+		Name:     "tick",
+		Schedule: scheduler.Every(200 * time.Millisecond),
+		Jitter:   20 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			fmt.Println("tick")
+			return nil
+		},
+	})
+
+	s.Add(scheduler.Job{
+		Name:     "slow",
+		Schedule: scheduler.Every(100 * time.Millisecond),
+		Overlap:  scheduler.Skip, // runs take 300ms, so most ticks are skipped
+		Run: func(ctx context.Context) error {
+			select {
+			case <-time.After(300 * time.Millisecond):
+				fmt.Println("slow done")
+			case <-ctx.Done(): // stop early when the scheduler stops
+			}
+			return nil
+		},
+	})
+
+	s.Add(scheduler.Job{
+		Name:     "panic",
+		Schedule: scheduler.Every(500 * time.Millisecond),
+		Run: func(ctx context.Context) error {
+			panic("something went wrong") // recovered, the other jobs keep running
+		},
+	})
+
+	// cron jobs look like this, they would run at 9:00 on weekdays
+	s.Add(scheduler.Job{
+		Name:     "report",
+		Schedule: scheduler.MustParseCron("0 9 * * 1-5"),
+		Run: func(ctx context.Context) error {
+			return nil
+		},
+	})
+
+	fmt.Println(s.Run(ctx)) // blocks until ctx is done and the running jobs finished
+}
+
 /*
 
 Contexts in Go are used to manage
@@ -982,13 +2046,26 @@ context.WithValue(parent Context, key, val interface{}) Context:
 
 Creates a new context with an added key-value pair. This is useful for passing additional data through the context.
 
+RealServ uses it for the request id: see requestlog.WithMetadata, the keys there are
+unexported struct types, so values of different packages never collide.
+
 
 
 ******/
 
 /// another example
 
-func exctractText(ctx context.Context, url string) (string, error) {
+// OutputFormat says how MakeParserContext prints the page
+type OutputFormat string
+
+const (
+	FormatText     OutputFormat = "text"     // only the text of the page
+	FormatJSON     OutputFormat = "json"     // title, headings, links and text as json
+	FormatMarkdown OutputFormat = "markdown" // the page as markdown notes
+)
+
+// fetchPage downloads the page and returns the parsed html tree
+func fetchPage(ctx context.Context, url string) (*html.Node, error) {
 	client := &http.Client{
 		Timeout: 5 * time.Second,
 	}
@@ -996,43 +2073,111 @@ func exctractText(ctx context.Context, url string) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse HTML: %w", err)
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
 	}
 
-	var extractText func(*html.Node) string
-	extractText = func(n *html.Node) string {
-		if n.Type == html.TextNode {
-			return n.Data
-		}
+	return doc, nil
+}
+
+func exctractText(ctx context.Context, url string) (string, error) {
+	doc, err := fetchPage(ctx, url)
+	if err != nil {
+		return "", err
+	}
 
-		text := ""
+	return extractText(doc), nil
+}
 
+func extractText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	text := ""
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text += extractText(c) + " "
+	}
+	return text
+
+}
+
+// Page is what we print for FormatJSON
+type Page struct {
+	URL      string   `json:"url"`
+	Title    string   `json:"title"`
+	Headings []string `json:"headings"`
+	Links    []string `json:"links"`
+	Text     string   `json:"text"`
+}
+
+func newPage(url string, doc *html.Node) Page {
+	page := Page{URL: url}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script, atom.Style:
+				return
+			case atom.Title:
+				page.Title = strings.Join(strings.Fields(extractText(n)), " ")
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				page.Headings = append(page.Headings, strings.Join(strings.Fields(extractText(n)), " "))
+			case atom.A:
+				for _, a := range n.Attr {
+					if a.Key == "href" && a.Val != "" {
+						page.Links = append(page.Links, a.Val)
+					}
+				}
+			}
+		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			text += extractText(c) + " "
+			walk(c)
 		}
-		return text
+	}
+	walk(doc)
+
+	page.Text = strings.Join(strings.Fields(extractText(doc)), " ")
+	return page
+}
 
+// renderPage turns the html tree into text, json or markdown
+func renderPage(url string, doc *html.Node, format OutputFormat) (string, error) {
+	switch format {
+	case FormatText, "":
+		return extractText(doc), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(newPage(url, doc), "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode page: %w", err)
+		}
+		return string(data), nil
+	case FormatMarkdown:
+		return markdown.Convert(doc), nil
 	}
-	return extractText(doc), nil
+	return "", fmt.Errorf("unknown output format: %q", format)
 }
 
-func MakeParserContext() {
+// MakeParserContext fetches the page and prints it in the given format
+// use FormatMarkdown to save a page as notes
+func MakeParserContext(format OutputFormat) {
 
 	fmt.Println("------")
 
@@ -1044,9 +2189,16 @@ func MakeParserContext() {
 
 	url := "https://easyoffer.ru/rating/golang_developer"
 
-	result, err := exctractText(ctx, url)
+	doc, err := fetchPage(ctx, url)
 	if err != nil {
 		fmt.Println(err)
+		return
+	}
+
+	result, err := renderPage(url, doc, format)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 	fmt.Println("the response took ", result)
 
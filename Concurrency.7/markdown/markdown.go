@@ -0,0 +1,383 @@
+// Package markdown turns the *html.Node tree from golang.org/x/net/html
+// into markdown text, so a fetched page can be saved as notes
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Convert renders the whole tree (usually the result of html.Parse) as markdown
+func Convert(n *html.Node) string {
+	c := &converter{}
+	return tidy(c.render(n))
+}
+
+type converter struct {
+	listDepth int // how deep we are inside ul/ol, nested lists are rendered tight
+}
+
+var spaces = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+func (c *converter) render(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escape(spaces.ReplaceAllString(n.Data, " "))
+	case html.DocumentNode:
+		return c.children(n)
+	case html.ElementNode:
+		// handled below
+	default:
+		return "" // comments, doctype
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Iframe, atom.Svg:
+		return ""
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		text := oneLine(c.children(n))
+		if text == "" {
+			return ""
+		}
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header,
+		atom.Footer, atom.Nav, atom.Aside, atom.Figure, atom.Figcaption:
+		return "\n\n" + strings.TrimSpace(c.children(n)) + "\n\n"
+
+	case atom.Br:
+		return "  \n"
+
+	case atom.Hr:
+		return "\n\n---\n\n"
+
+	case atom.Strong, atom.B:
+		return wrap(c.children(n), "**")
+
+	case atom.Em, atom.I:
+		return wrap(c.children(n), "*") // _ is not emphasis inside a word (x_a_b)
+
+	case atom.Del, atom.S:
+		return wrap(c.children(n), "~~")
+
+	case atom.Code:
+		return inlineCode(textContent(n))
+
+	case atom.Pre:
+		return c.codeBlock(n)
+
+	case atom.A:
+		return c.link(n)
+
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + escape(attr(n, "alt")) + "](" + destination(src) + ")"
+
+	case atom.Ul:
+		return c.list(n, false)
+
+	case atom.Ol:
+		return c.list(n, true)
+
+	case atom.Blockquote:
+		text := strings.TrimSpace(tidy(c.children(n)))
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+
+	case atom.Table:
+		return c.table(n)
+	}
+
+	return c.children(n)
+}
+
+func (c *converter) children(n *html.Node) string {
+	var b strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		b.WriteString(c.render(ch))
+	}
+	return b.String()
+}
+
+func (c *converter) link(n *html.Node) string {
+	text := oneLine(c.children(n))
+	href := attr(n, "href")
+
+	if href == "" || strings.HasPrefix(href, "javascript:") {
+		return text
+	}
+	if text == "" {
+		text = escape(href)
+	}
+	href = destination(href)
+	if title := attr(n, "title"); title != "" {
+		return "[" + text + "](" + href + " " + strconv.Quote(title) + ")"
+	}
+	return "[" + text + "](" + href + ")"
+}
+
+func (c *converter) codeBlock(n *html.Node) string {
+	lang := ""
+	// <pre><code class="language-go"> is the common way to mark the language
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.Type == html.ElementNode && ch.DataAtom == atom.Code {
+			for _, class := range strings.Fields(attr(ch, "class")) {
+				if l, ok := strings.CutPrefix(class, "language-"); ok {
+					lang = l
+				}
+			}
+		}
+	}
+
+	code := strings.Trim(textContent(n), "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return "\n\n" + fence + lang + "\n" + code + "\n" + fence + "\n\n"
+}
+
+func (c *converter) list(n *html.Node, ordered bool) string {
+	c.listDepth++
+	defer func() { c.listDepth-- }()
+
+	index := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		index = start
+	}
+
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(index) + ". "
+			index++
+		}
+
+		// nested lists are indented under the marker of the parent item
+		body := strings.TrimSpace(tidy(c.children(li)))
+		indent := strings.Repeat(" ", len(marker))
+		lines := strings.Split(body, "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	if len(items) == 0 {
+		return ""
+	}
+	if c.listDepth > 1 {
+		return "\n" + strings.Join(items, "\n") + "\n"
+	}
+	return "\n\n" + strings.Join(items, "\n") + "\n\n"
+}
+
+func (c *converter) table(n *html.Node) string {
+	var rows [][]string
+	header := false
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if ch.Type != html.ElementNode {
+				continue
+			}
+			switch ch.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(ch)
+			case atom.Tr:
+				var row []string
+				for cell := ch.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode {
+						continue
+					}
+					if cell.DataAtom != atom.Th && cell.DataAtom != atom.Td {
+						continue
+					}
+					if len(rows) == 0 && cell.DataAtom == atom.Th {
+						header = true
+					}
+					row = append(row, oneLine(c.children(cell))) // | is escaped already
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	walk(n)
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	// markdown tables always need a header row, so add an empty one if the html has none
+	if !header {
+		rows = append([][]string{make([]string, columns)}, rows...)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n")
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// wrap puts a marker like ** around the text but keeps the spaces outside,
+// "** bold **" is not bold in markdown
+func wrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+	return lead + marker + trimmed + marker + trail
+}
+
+// escaper puts a backslash before the characters that mean something in
+// markdown text, so "Title *x*" stays text and "l]nk" doesn't end a link
+var escaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"(", `\(`, ")", `\)`, "#", `\#`, "`", "\\`", "|", `\|`,
+)
+
+// these only mean something at the start of a line: "- x" is a list, "> x"
+// a quote, "1. x" a numbered list, "===" a heading underline and "~~~" a
+// code fence. We don't know if a text node starts a line, but a backslash
+// before punctuation is always allowed, so the start of every text node
+// gets one.
+var (
+	blockMarker = regexp.MustCompile(`^(\s*)([-+>=~])`)
+	listNumber  = regexp.MustCompile(`^(\s*\d{1,9})([.)])`)
+)
+
+func escape(text string) string {
+	text = escaper.Replace(text)
+	text = blockMarker.ReplaceAllString(text, `$1\$2`)
+	return listNumber.ReplaceAllString(text, `$1\$2`)
+}
+
+// destination makes a url safe inside (...): a space or a parenthesis
+// would end it early, the percent-escaped form means the same url
+var destinationEscaper = strings.NewReplacer(
+	" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E",
+)
+
+func destination(url string) string {
+	return destinationEscaper.Replace(url)
+}
+
+func inlineCode(code string) string {
+	code = spaces.ReplaceAllString(code, " ")
+	if code == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		return fence + " " + code + " " + fence
+	}
+	return fence + code + fence
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		b.WriteString(textContent(ch))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func oneLine(s string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+// tidy removes whitespace-only lines and squeezes runs of blank lines into one,
+// fenced code blocks are left as they are
+func tidy(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	fence := ""
+	blank := false
+
+	for _, line := range lines {
+		if fence != "" {
+			out = append(out, line)
+			if strings.TrimSpace(line) == fence {
+				fence = ""
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+
+		if trimmed := strings.TrimLeft(line, " "); strings.HasPrefix(trimmed, "```") {
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+		}
+		out = append(out, strings.TrimRight(line, " \t")+trailingBreak(line))
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n")) + "\n"
+}
+
+// trailingBreak keeps the two spaces that <br> leaves at the end of a line
+func trailingBreak(line string) string {
+	if strings.HasSuffix(line, "  ") && strings.TrimSpace(line) != "" {
+		return "  "
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestConvertEscapes(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		// block markers at the start of a line
+		{"<p>- not a list</p>", `\- not a list`},
+		{"<p>+ not a list</p>", `\+ not a list`},
+		{"<p>&gt; not a quote</p>", `\> not a quote`},
+		{"<p>1. not numbered</p>", `1\. not numbered`},
+		{"<p>2026) a year</p>", `2026\) a year`},
+		{"<p>| a | b |</p>", `\| a \| b \|`},
+		{"<p>line<br>- after a break</p>", "line  \n\\- after a break"},
+		{"<p>title<br>===</p>", "title  \n\\==="},
+		{"<ul><li>- item</li></ul>", `- \- item`},
+
+		// inside a line they are text anyway
+		{"<p>a - b + c &gt; d 3. e</p>", "a - b + c > d 3. e"},
+
+		// inline markers anywhere
+		{"<p>Title *x* and x_a_b</p>", `Title \*x\* and x\_a\_b`},
+		{"<p>[not](a link) # `code`</p>", "\\[not\\]\\(a link\\) \\# \\`code\\`"},
+		{`<p>back\slash</p>`, `back\\slash`},
+
+		// markup still works
+		{"<p><em>emphasis</em> <strong>- bold</strong></p>", `*emphasis* **\- bold**`},
+		{`<p><a href="/a b(c)">l]nk</a></p>`, `[l\]nk](/a%20b%28c%29)`},
+		{`<p><a href="/x"></a></p>`, `[/x](/x)`},
+		{`<img src="i.png" alt="*alt*">`, `![\*alt\*](i.png)`},
+		{"<table><tr><th>a|b</th></tr><tr><td>c</td></tr></table>", "| a\\|b |\n| --- |\n| c |"},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(Convert(doc), "\n"); got != tt.want {
+			t.Errorf("%s\n got %q\nwant %q", tt.html, got, tt.want)
+		}
+	}
+}
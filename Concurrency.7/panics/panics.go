@@ -0,0 +1,72 @@
+// Package panics turns a panic in a goroutine into an error
+//
+// A panic that nobody recovers kills the whole process, and recover only
+// works in a deferred call of the same goroutine. Functions.3 shows it with
+// handlePunic, but every caller has to remember the defer. SafeGo and Call
+// do it every time and keep the stack of the panic, which is the part you
+// need to fix the bug.
+package panics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic
+type PanicError struct {
+	Value any    // what was passed to panic
+	Stack []byte // the goroutine stack at the panic
+}
+
+// Error is one line like any error, log Stack next to it when you need it
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap gives errors.Is/As access to the value if it was an error,
+// panic(io.EOF) is still io.EOF
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Call runs fn and returns a *PanicError if it panicked, otherwise its error
+func Call(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+type handlerKey struct{}
+
+// WithHandler returns a context whose SafeGo goroutines report to h
+func WithHandler(ctx context.Context, h func(error)) context.Context {
+	return context.WithValue(ctx, handlerKey{}, h)
+}
+
+// SafeGo runs fn in a new goroutine, a panic or an error goes to the
+// handler of ctx (see WithHandler) or to slog when there is none
+func SafeGo(ctx context.Context, fn func(ctx context.Context) error) {
+	go func() {
+		err := Call(func() error { return fn(ctx) })
+		if err == nil {
+			return
+		}
+		if h, ok := ctx.Value(handlerKey{}).(func(error)); ok && h != nil {
+			h(err)
+			return
+		}
+		var p *PanicError
+		if errors.As(err, &p) {
+			slog.ErrorContext(ctx, "goroutine panicked", "err", err, "stack", string(p.Stack))
+			return
+		}
+		slog.ErrorContext(ctx, "goroutine failed", "err", err)
+	}()
+}
@@ -0,0 +1,211 @@
+// Package parallel has divide-and-conquer versions of sort, reduce and
+// prefix sum that split a slice between goroutines
+//
+// Starting a goroutine and merging the parts costs something, so small
+// slices (below Options.Threshold) are done with the plain sequential loop,
+// and there are never more goroutines than Options.Procs (GOMAXPROCS by
+// default): more goroutines than processors only add switching.
+package parallel
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Options tune the split, the zero value means the defaults
+type Options struct {
+	Threshold int // below this length the work is sequential, default 4096
+	Procs     int // goroutines at most, default runtime.GOMAXPROCS(0)
+}
+
+func options(opts []Options) (threshold, procs int) {
+	var o Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	threshold, procs = o.Threshold, o.Procs
+	if threshold <= 0 {
+		threshold = 4096
+	}
+	if procs <= 0 {
+		procs = runtime.GOMAXPROCS(0)
+	}
+	return threshold, procs
+}
+
+// Number is what Sum and PrefixSum add up
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// SortFunc sorts s by cmp with a merge sort, it's stable:
+// equal elements keep their order
+func SortFunc[T any](s []T, cmp func(a, b T) int, opts ...Options) {
+	threshold, procs := options(opts)
+	buf := make([]T, len(s))
+	mergeSort(s, buf, cmp, threshold, procs)
+}
+
+// mergeSort sorts both halves at the same time while there are processors
+// left, every level of recursion halves procs
+func mergeSort[T any](s, buf []T, cmp func(a, b T) int, threshold, procs int) {
+	if len(s) <= threshold || procs <= 1 {
+		sequentialSort(s, buf, cmp)
+		return
+	}
+
+	mid := len(s) / 2
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeSort(s[:mid], buf[:mid], cmp, threshold, procs/2)
+	}()
+	mergeSort(s[mid:], buf[mid:], cmp, threshold, procs-procs/2)
+	wg.Wait()
+
+	merge(s, mid, buf, cmp)
+}
+
+// insertionRun is the size below which insertion sort beats merging
+const insertionRun = 24
+
+// sequentialSort is the same merge sort on one goroutine, we have buf already
+// (slices.SortStableFunc needs no buffer but moves elements much more)
+func sequentialSort[T any](s, buf []T, cmp func(a, b T) int) {
+	if len(s) <= insertionRun {
+		for i := 1; i < len(s); i++ {
+			for j := i; j > 0 && cmp(s[j], s[j-1]) < 0; j-- {
+				s[j], s[j-1] = s[j-1], s[j]
+			}
+		}
+		return
+	}
+	mid := len(s) / 2
+	sequentialSort(s[:mid], buf[:mid], cmp)
+	sequentialSort(s[mid:], buf[mid:], cmp)
+	merge(s, mid, buf, cmp)
+}
+
+// merge merges the sorted s[:mid] and s[mid:] through buf
+func merge[T any](s []T, mid int, buf []T, cmp func(a, b T) int) {
+	if cmp(s[mid-1], s[mid]) <= 0 {
+		return // already in order, common for nearly sorted data
+	}
+
+	i, j, k := 0, mid, 0
+	for i < mid && j < len(s) {
+		if cmp(s[j], s[i]) < 0 { // take the right one only if strictly less: stable
+			buf[k] = s[j]
+			j++
+		} else {
+			buf[k] = s[i]
+			i++
+		}
+		k++
+	}
+	k += copy(buf[k:], s[i:mid])
+	k += copy(buf[k:], s[j:])
+	copy(s, buf[:k])
+}
+
+// Reduce combines all elements with op, op must be associative
+// ((a op b) op c == a op (b op c)) because every goroutine reduces its own
+// chunk first. The order of the elements is kept, op needs not be commutative.
+func Reduce[T any](s []T, identity T, op func(a, b T) T, opts ...Options) T {
+	threshold, procs := options(opts)
+
+	chunks := split(len(s), threshold, procs)
+	partial := make([]T, len(chunks))
+	each(chunks, func(i int, c chunk) {
+		acc := identity
+		for _, v := range s[c.from:c.to] {
+			acc = op(acc, v)
+		}
+		partial[i] = acc
+	})
+
+	acc := identity
+	for _, v := range partial {
+		acc = op(acc, v)
+	}
+	return acc
+}
+
+// Sum is Reduce with +
+func Sum[T Number](s []T, opts ...Options) T {
+	return Reduce(s, 0, func(a, b T) T { return a + b }, opts...)
+}
+
+// Scan replaces every element with op of all elements up to it (inclusive
+// prefix), op must be associative. It's two passes over the chunks: sums of
+// the chunks in parallel, then every chunk adds the sum of the chunks
+// before it, also in parallel.
+func Scan[T any](s []T, identity T, op func(a, b T) T, opts ...Options) {
+	threshold, procs := options(opts)
+
+	chunks := split(len(s), threshold, procs)
+	totals := make([]T, len(chunks))
+	each(chunks, func(i int, c chunk) {
+		acc := identity
+		for j := c.from; j < c.to; j++ {
+			acc = op(acc, s[j])
+			s[j] = acc
+		}
+		totals[i] = acc
+	})
+	if len(chunks) <= 1 {
+		return
+	}
+
+	// offsets are few (one per chunk), a loop is enough
+	offsets := make([]T, len(chunks))
+	acc := identity
+	for i, t := range totals {
+		offsets[i] = acc
+		acc = op(acc, t)
+	}
+
+	each(chunks[1:], func(i int, c chunk) {
+		off := offsets[i+1]
+		for j := c.from; j < c.to; j++ {
+			s[j] = op(off, s[j])
+		}
+	})
+}
+
+// PrefixSum is Scan with +: s[i] becomes s[0] + ... + s[i]
+func PrefixSum[T Number](s []T, opts ...Options) {
+	Scan(s, 0, func(a, b T) T { return a + b }, opts...)
+}
+
+type chunk struct{ from, to int }
+
+// split cuts n into at most procs chunks of at least threshold elements
+func split(n, threshold, procs int) []chunk {
+	parts := min(procs, max(n/threshold, 1))
+	chunks := make([]chunk, parts)
+	for i := range chunks {
+		chunks[i] = chunk{from: n * i / parts, to: n * (i + 1) / parts}
+	}
+	return chunks
+}
+
+// each runs fn for every chunk, one goroutine per chunk but the first one
+// runs on the calling goroutine
+func each(chunks []chunk, fn func(i int, c chunk)) {
+	var wg sync.WaitGroup
+	for i := 1; i < len(chunks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i, chunks[i])
+		}()
+	}
+	if len(chunks) > 0 {
+		fn(0, chunks[0])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,89 @@
+package parallel
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+// the crossover: below some size the sequential code is faster, the
+// goroutines and the merging cost more than they save. Threshold 1 makes
+// the parallel versions split at every size. With one processor
+// (-cpu 1) they are never faster.
+//
+//	go test -bench . ./parallel
+
+var always = Options{Threshold: 1}
+
+var sizes = []int{1_000, 10_000, 100_000, 1_000_000}
+
+func randomInts(n int) []int {
+	data := make([]int, n)
+	for i := range data {
+		data[i] = rand.IntN(n)
+	}
+	return data
+}
+
+// the copy is in both, so the difference is the sort
+func BenchmarkSort(b *testing.B) {
+	for _, n := range sizes {
+		data, work := randomInts(n), make([]int, n)
+
+		b.Run(fmt.Sprintf("sequential/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copy(work, data)
+				sort.Slice(work, func(i, j int) bool { return work[i] < work[j] })
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copy(work, data)
+				SortFunc(work, func(a, b int) int { return a - b }, always)
+			}
+		})
+	}
+}
+
+func BenchmarkSum(b *testing.B) {
+	for _, n := range sizes {
+		data := randomInts(n)
+
+		b.Run(fmt.Sprintf("sequential/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				total := 0
+				for _, v := range data {
+					total += v
+				}
+				_ = total
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Sum(data, always)
+			}
+		})
+	}
+}
+
+func BenchmarkPrefixSum(b *testing.B) {
+	for _, n := range sizes {
+		data, work := randomInts(n), make([]int, n)
+
+		b.Run(fmt.Sprintf("sequential/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copy(work, data)
+				for j := 1; j < len(work); j++ {
+					work[j] += work[j-1]
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copy(work, data)
+				PrefixSum(work, always)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Package queue is a bounded blocking queue on a mutex and two sync.Conds
+//
+// A buffered channel is a blocking queue too and it's the first thing to
+// use. It can't do a few things: look at the next item without taking it,
+// take everything that is in it at once, or tell a blocked sender that the
+// queue is closed (a send on a closed channel panics). BlockingQueue can,
+// it pays with a lock on every operation.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Put and Offer after Close, and by Take and
+	// Poll when the queue is closed and empty
+	ErrClosed = errors.New("queue: closed")
+
+	// ErrTimeout is returned by Offer and Poll when the time is up
+	ErrTimeout = errors.New("queue: timed out")
+)
+
+// BlockingQueue is a FIFO of at most Cap items, create it with New
+type BlockingQueue[T any] struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond // signaled by Put, Take waits on it
+	notFull  *sync.Cond // signaled by Take, Put waits on it
+
+	buf    []T // ring buffer
+	head   int // index of the next item to take
+	count  int
+	closed bool
+}
+
+func New[T any](capacity int) *BlockingQueue[T] {
+	if capacity < 1 {
+		panic("queue: capacity must be positive")
+	}
+	q := &BlockingQueue[T]{buf: make([]T, capacity)}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// Put adds v, waiting for room until ctx is done
+func (q *BlockingQueue[T]) Put(ctx context.Context, v T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.wait(ctx, q.notFull, func() bool { return q.count < len(q.buf) }); err != nil {
+		return err
+	}
+	if q.closed {
+		return ErrClosed
+	}
+	q.push(v)
+	return nil
+}
+
+// Take removes the oldest item, waiting for one until ctx is done. The items
+// left in a closed queue can still be taken, then it's ErrClosed.
+func (q *BlockingQueue[T]) Take(ctx context.Context) (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var zero T
+	if err := q.wait(ctx, q.notEmpty, func() bool { return q.count > 0 }); err != nil {
+		return zero, err
+	}
+	if q.count == 0 { // closed and nothing left
+		return zero, ErrClosed
+	}
+	return q.pop(), nil
+}
+
+// Offer is Put that waits at most timeout, 0 means don't wait at all
+func (q *BlockingQueue[T]) Offer(v T, timeout time.Duration) error {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	return timedOut(q.Put(ctx, v))
+}
+
+// Poll is Take that waits at most timeout, 0 means don't wait at all
+func (q *BlockingQueue[T]) Poll(timeout time.Duration) (T, error) {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	v, err := q.Take(ctx)
+	return v, timedOut(err)
+}
+
+// Peek returns the next item without taking it
+func (q *BlockingQueue[T]) Peek() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.count == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.buf[q.head], true
+}
+
+// Drain takes all items at once without waiting, also from a closed queue
+func (q *BlockingQueue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]T, 0, q.count)
+	for q.count > 0 {
+		items = append(items, q.pop())
+	}
+	q.notFull.Broadcast() // there is room for all the waiting producers
+	return items
+}
+
+// Len is the number of items right now
+func (q *BlockingQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Cap is the most items the queue holds
+func (q *BlockingQueue[T]) Cap() int {
+	return len(q.buf)
+}
+
+// Close wakes every waiting Put and Take. Put fails from now on, Take gets
+// the items still in the queue and then ErrClosed. Closing twice is a no-op.
+func (q *BlockingQueue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// wait waits on c until ready, Close or ctx, q.mu is held. Cond.Wait knows
+// nothing about contexts, so when ctx is done a goroutine wakes c for us.
+func (q *BlockingQueue[T]) wait(ctx context.Context, c *sync.Cond, ready func() bool) error {
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			q.mu.Lock() // not before we are in Wait, so the Broadcast isn't lost
+			c.Broadcast()
+			q.mu.Unlock()
+		})
+		defer stop()
+	}
+
+	// ready is checked before ctx: a waiter woken by Signal always uses it,
+	// otherwise the wakeup would be lost for the other waiters
+	for !ready() && !q.closed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.Wait()
+	}
+	return nil
+}
+
+func (q *BlockingQueue[T]) push(v T) {
+	q.buf[(q.head+q.count)%len(q.buf)] = v
+	q.count++
+	q.notEmpty.Signal()
+}
+
+func (q *BlockingQueue[T]) pop() T {
+	var zero T
+	v := q.buf[q.head]
+	q.buf[q.head] = zero // don't keep the item alive for the GC
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+	q.notFull.Signal()
+	return v
+}
+
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // done already: ready or not, no waiting
+		return ctx, cancel
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func timedOut(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+// Package requestlog gives every http request an id, keeps it in the context
+// and adds it to every log line written with that context through log/slog
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Header is where the id comes from and where we send it back
+const Header = "X-Request-ID"
+
+// Metadata describes the request the context belongs to
+type Metadata struct {
+	ID         string
+	Method     string
+	Path       string
+	RemoteAddr string
+	Start      time.Time
+}
+
+// context keys are unexported types, so no other package can
+// overwrite our values by accident (a string key could collide)
+type (
+	idKey       struct{}
+	metadataKey struct{}
+)
+
+// WithMetadata returns a context that carries the request id and metadata
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	ctx = context.WithValue(ctx, idKey{}, m.ID)
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// ID returns the request id of the context, or "" outside a request
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// FromContext returns the metadata of the request
+func FromContext(ctx context.Context) (Metadata, bool) {
+	m, ok := ctx.Value(metadataKey{}).(Metadata)
+	return m, ok
+}
+
+// Middleware takes the id from the X-Request-ID header (so one id follows the
+// request through several services) or makes a new one, puts it into the
+// context and the response header and logs when the request is done
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !validID(id) {
+			id = newID()
+		}
+
+		m := Metadata{
+			ID:         id,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			Start:      time.Now(),
+		}
+		ctx := WithMetadata(r.Context(), m)
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		logger.InfoContext(ctx, "request done", "duration", time.Since(m.Start))
+	})
+}
+
+// validID accepts what the client sent only if it's short and printable,
+// it ends up in our logs
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Format of the log output
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// NewLogger writes text or json to w, every record logged with a request
+// context gets request_id, method and path
+func NewLogger(w io.Writer, format Format, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("requestlog: unknown log format %q", format)
+	}
+
+	return slog.New(Handler{h}), nil
+}
+
+// Handler adds the request fields from the context to every record
+// wrap any slog.Handler with it
+type Handler struct {
+	slog.Handler
+}
+
+func (h Handler) Handle(ctx context.Context, r slog.Record) error {
+	if m, ok := FromContext(ctx); ok {
+		r.AddAttrs(
+			slog.String("request_id", m.ID),
+			slog.String("method", m.Method),
+			slog.String("path", m.Path),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h Handler) WithGroup(name string) slog.Handler {
+	return Handler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,163 @@
+// Package runloop runs the parts of a program until SIGINT or SIGTERM and
+// then stops them in order, instead of a time.Sleep before the end of main
+//
+//	var g runloop.Group
+//	g.Add("db", db.Run, db.Close)
+//	g.Add("http", srv.Run, srv.Shutdown) // uses db, so it's stopped first
+//	runloop.Exit(g.Run(context.Background()))
+//
+// Components are started in the order they were added and stopped in the
+// reverse order: what was started last depends on what was started before.
+package runloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long all components together have to stop
+const DefaultShutdownTimeout = 10 * time.Second
+
+// ErrStopTimeout is in the error of Run for a component that didn't stop in time
+var ErrStopTimeout = errors.New("runloop: component didn't stop before the deadline")
+
+// Group is the list of components, the zero value is ready to use
+type Group struct {
+	ShutdownTimeout time.Duration // DefaultShutdownTimeout when 0
+
+	components []component
+}
+
+type component struct {
+	name string
+	run  func(ctx context.Context) error
+	stop func(ctx context.Context) error
+}
+
+// Add registers a component. run does the work and returns when the component
+// is done, a run that returns ends the whole group (a server that died, or a
+// job that finished). stop asks it to return before the deadline of its ctx;
+// the ctx given to run is canceled right after stop, so stop may be nil for
+// a run that ends on ctx.
+func (g *Group) Add(name string, run, stop func(ctx context.Context) error) {
+	g.components = append(g.components, component{name: name, run: run, stop: stop})
+}
+
+// Run starts every component and waits for SIGINT, SIGTERM, the end of ctx,
+// or the first run that returns. Then it stops the components in the reverse
+// order. The error has every component that failed: its run returned an
+// error, its stop did, or it didn't stop in time.
+func (g *Group) Run(ctx context.Context) error {
+	sigctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(g.components)) // buffered, late runs never block
+	cancels := make([]context.CancelFunc, len(g.components))
+	done := make([]chan struct{}, len(g.components))
+	errs := make([]error, len(g.components))
+
+	for i, c := range g.components {
+		// the components don't see ctx canceled all at once, each is
+		// canceled when its turn to stop comes; values are kept
+		cctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cancels[i], done[i] = cancel, make(chan struct{})
+		go func() {
+			defer close(done[i])
+			err := c.run(cctx)
+			results <- result{i, err}
+		}()
+	}
+
+	var cause error
+	select {
+	case <-sigctx.Done():
+		cause = errors.New("runloop: got a signal")
+		if ctx.Err() != nil {
+			cause = fmt.Errorf("runloop: %w", context.Cause(ctx))
+		}
+	case r := <-results:
+		cause = fmt.Errorf("runloop: %s returned", g.components[r.i].name)
+		errs[r.i] = runErr(r.err)
+	}
+	// the first signal is ours, a second Ctrl-C during a slow shutdown
+	// kills the process as usual
+	stopSignals()
+
+	timeout := g.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	// the deadline doesn't depend on ctx: it may be what ended the group
+	shutdown, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	for i := len(g.components) - 1; i >= 0; i-- {
+		c := g.components[i]
+		if c.stop != nil {
+			select {
+			case <-done[i]: // returned already, nothing to stop
+			default:
+				if err := c.stop(shutdown); err != nil {
+					errs[i] = errors.Join(errs[i], err)
+				}
+			}
+		}
+		cancels[i]()
+
+		// select picks at random when both are ready: a component that
+		// returned is not late, even if the deadline has passed meanwhile
+		select {
+		case <-done[i]:
+			continue
+		default:
+		}
+		select {
+		case <-done[i]:
+		case <-shutdown.Done():
+			errs[i] = errors.Join(errs[i], ErrStopTimeout)
+		}
+	}
+
+	// the errors of the runs that returned, we are the only reader
+	for len(results) > 0 {
+		r := <-results
+		errs[r.i] = errors.Join(errs[r.i], runErr(r.err))
+	}
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", g.components[i].name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v: %w", cause, errors.Join(failed...))
+	}
+	return nil
+}
+
+// runErr drops the error of a run that returned because its ctx was
+// canceled, that's how we asked it to stop
+func runErr(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// Exit ends the process with status 1 if err is not nil, for the end of main
+func Exit(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is where the scheduler gets the time from
+// the real one is used by default, ManualClock lets you move time by hand
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of *time.Timer the scheduler needs
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+
+func (t realTimer) Stop() bool { return t.t.Stop() }
+
+// ManualClock only moves when Advance is called, so schedules can be checked
+// without real waiting
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*manualTimer
+	changed chan struct{} // closed and replaced every time the list of timers changes
+}
+
+// NewManualClock starts the clock at the given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now, changed: make(chan struct{})}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.notify()
+	return t
+}
+
+// Advance moves the time forward and fires every timer that is due
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	kept := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			kept = append(kept, t)
+			continue
+		}
+		t.ch <- c.now // buffered, one value per timer
+	}
+	c.timers = kept
+	c.notify()
+}
+
+// BlockUntil waits until n timers are waiting on the clock,
+// use it to be sure the scheduler is asleep before calling Advance
+func (c *ManualClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		count, changed := len(c.timers), c.changed
+		c.mu.Unlock()
+
+		if count >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (c *ManualClock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type manualTimer struct {
+	clock *ManualClock
+	at    time.Time
+	ch    chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time { return t.ch }
+
+func (t *manualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.notify()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a job runs next
+type Schedule interface {
+	// Next returns the first run time after t, zero time means never again
+	Next(t time.Time) time.Time
+}
+
+// Every runs a job with a fixed interval
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	if e <= 0 {
+		return time.Time{}
+	}
+	return t.Add(time.Duration(e))
+}
+
+// cron keeps one bit for every allowed value of a field
+type cron struct {
+	minute, hour, dom, month, dow uint64
+
+	anyDom, anyDow bool // day of month or day of week starts with "*"
+}
+
+type field struct {
+	min, max int
+}
+
+var (
+	minutes     = field{0, 59}
+	hours       = field{0, 23}
+	daysOfMonth = field{1, 31}
+	months      = field{1, 12}
+	daysOfWeek  = field{0, 7} // 0 and 7 are both sunday
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron understands the classic five fields
+// "minute hour day-of-month month day-of-week" with *, lists, ranges and steps,
+// the @hourly/@daily/... shortcuts and "@every 90s"
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("cron %q: interval must be positive", expr)
+		}
+		return Every(interval), nil
+	}
+	if full, ok := descriptors[expr]; ok {
+		expr = full
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var c cron
+	var err error
+	if c.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, fmt.Errorf("cron %q: minute: %w", expr, err)
+	}
+	if c.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, fmt.Errorf("cron %q: hour: %w", expr, err)
+	}
+	if c.dom, err = parseField(fields[2], daysOfMonth); err != nil {
+		return nil, fmt.Errorf("cron %q: day of month: %w", expr, err)
+	}
+	if c.month, err = parseField(fields[3], months); err != nil {
+		return nil, fmt.Errorf("cron %q: month: %w", expr, err)
+	}
+	if c.dow, err = parseField(fields[4], daysOfWeek); err != nil {
+		return nil, fmt.Errorf("cron %q: day of week: %w", expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is sunday too
+	}
+	c.anyDom = strings.HasPrefix(fields[2], "*")
+	c.anyDow = strings.HasPrefix(fields[4], "*")
+
+	return &c, nil
+}
+
+// MustParseCron is like ParseCron but panics, handy for constant expressions
+func MustParseCron(expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseNumber(a, f); err != nil {
+				return 0, err
+			}
+			if hi, err = parseNumber(b, f); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		default:
+			n, err := parseNumber(rng, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if !hasStep {
+				hi = n // "5/10" means from 5 to the end with step 10
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func parseNumber(s string, f field) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	// cron works with whole minutes
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// if nothing matched in five years the expression can't fire (like "0 0 30 2 *")
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows the usual cron rule: when both day fields are restricted
+// it's enough for one of them to match
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,281 @@
+// Package scheduler runs periodic jobs on fixed intervals or cron expressions,
+// instead of for { work(); time.Sleep(d) } loops
+package scheduler
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// OverlapPolicy says what happens when a job is due but its last run is not finished
+type OverlapPolicy int
+
+const (
+	Skip       OverlapPolicy = iota // drop this run
+	Queue                           // run once more right after the current run ends, however many runs were due
+	Concurrent                      // start another run next to the current one
+)
+
+// Job is one periodic task
+type Job struct {
+	Name     string
+	Schedule Schedule // Every(d) or ParseCron(expr)
+	Run      func(ctx context.Context) error
+	Overlap  OverlapPolicy // Skip by default
+
+	// Jitter delays every run by a random duration up to Jitter,
+	// so many instances of the same job don't all fire at once
+	Jitter time.Duration
+}
+
+// Scheduler runs the jobs until the context given to Run is done
+// the zero value uses the real clock and logs job errors
+type Scheduler struct {
+	Clock   Clock                       // nil means the real clock
+	OnError func(job string, err error) // job errors and recovered panics, nil means log them
+
+	mu      sync.Mutex
+	entries []*entry
+	wake    chan struct{} // Add tells the run loop that there is a new job
+	idle    *sync.Cond    // broadcast when a job stops running, see WaitIdle
+}
+
+type entry struct {
+	job Job
+
+	planned time.Time // when the schedule says the job runs, without jitter
+	fireAt  time.Time // planned + jitter
+
+	running int  // runs in progress
+	queued  bool // one more run because of the Queue policy
+}
+
+var (
+	// ErrNoSchedule is returned by Add for a job without Schedule or Run
+	ErrNoSchedule = errors.New("scheduler: job needs a Schedule and a Run func")
+
+	// ErrNoName is returned by Add for a job without a Name, the name is
+	// how jobs are told apart
+	ErrNoName = errors.New("scheduler: job needs a Name")
+)
+
+// Add registers a job, it can be called before or while Run is working
+func (s *Scheduler) Add(job Job) error {
+	if job.Schedule == nil || job.Run == nil {
+		return ErrNoSchedule
+	}
+	if job.Name == "" {
+		return ErrNoName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.job.Name == job.Name {
+			return fmt.Errorf("scheduler: job %q already added", job.Name)
+		}
+	}
+
+	e := &entry{job: job}
+	e.plan(s.clock().Now())
+	s.entries = append(s.entries, e)
+
+	if s.wake != nil {
+		select {
+		case s.wake <- struct{}{}:
+		default: // the loop is already going to look at the jobs
+		}
+	}
+	return nil
+}
+
+// Run blocks and starts the jobs on time, when ctx is done it stops
+// starting new runs, waits for the running ones and returns ctx.Err()
+//
+// The jobs get a context that is canceled when ctx is done,
+// so a long job can finish early on shutdown.
+func (s *Scheduler) Run(ctx context.Context) error {
+	clock := s.clock()
+
+	s.mu.Lock()
+	if s.wake != nil {
+		s.mu.Unlock()
+		return errors.New("scheduler: already running")
+	}
+	s.wake = make(chan struct{}, 1)
+	wake := s.wake
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait() // graceful stop, running jobs are allowed to finish
+
+		s.mu.Lock()
+		s.wake = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		now := clock.Now()
+
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if e.planned.IsZero() || e.fireAt.After(now) {
+				continue
+			}
+			s.dispatch(ctx, &wg, e)
+			e.plan(e.planned)
+
+			// we were asleep too long (or the job is too slow), don't fire all missed runs
+			for !e.planned.IsZero() && !e.planned.After(now) {
+				e.plan(e.planned)
+			}
+		}
+		next := s.nextFire()
+		s.mu.Unlock()
+
+		// no jobs (or none will ever run again) means a nil channel, we only wait for wake or ctx
+		var timer Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer = clock.NewTimer(next.Sub(now))
+			fire = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-fire:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// dispatch starts the job or applies the overlap policy, s.mu is held
+func (s *Scheduler) dispatch(ctx context.Context, wg *sync.WaitGroup, e *entry) {
+	if e.running > 0 {
+		switch e.job.Overlap {
+		case Skip:
+			return
+		case Queue:
+			e.queued = true // a second due run doesn't add a third one
+			return
+		}
+	}
+
+	e.running++
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			s.runJob(ctx, e.job)
+
+			s.mu.Lock()
+			if e.queued && ctx.Err() == nil {
+				e.queued = false
+				s.mu.Unlock()
+				continue
+			}
+			e.queued = false
+			e.running--
+			s.cond().Broadcast()
+			s.mu.Unlock()
+			return
+		}
+	}()
+}
+
+// WaitIdle waits until no job is running or queued. With a ManualClock it's
+// how a test knows the runs started by Advance are over:
+//
+//	clock.BlockUntil(1) // the scheduler sleeps
+//	clock.Advance(time.Minute)
+//	clock.BlockUntil(1) // it woke up, started the due jobs and sleeps again
+//	s.WaitIdle()        // and the jobs returned
+func (s *Scheduler) WaitIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.busy() {
+		s.cond().Wait()
+	}
+}
+
+// busy says whether a job runs, s.mu is held
+func (s *Scheduler) busy() bool {
+	for _, e := range s.entries {
+		if e.running > 0 || e.queued {
+			return true
+		}
+	}
+	return false
+}
+
+// cond creates idle on first use, the zero Scheduler is ready to use; s.mu is held
+func (s *Scheduler) cond() *sync.Cond {
+	if s.idle == nil {
+		s.idle = sync.NewCond(&s.mu)
+	}
+	return s.idle
+}
+
+// runJob calls the job and turns a panic into an error, so one bad job
+// doesn't kill the scheduler
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	err := panics.Call(func() error { return job.Run(ctx) })
+
+	if err == nil || errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return
+	}
+	if s.OnError != nil {
+		s.OnError(job.Name, err)
+		return
+	}
+	var p *panics.PanicError
+	if errors.As(err, &p) {
+		log.Printf("scheduler: job %q: %v\n%s", job.Name, err, p.Stack)
+		return
+	}
+	log.Printf("scheduler: job %q: %v", job.Name, err)
+}
+
+func (s *Scheduler) nextFire() time.Time {
+	var next time.Time
+	for _, e := range s.entries {
+		if e.planned.IsZero() {
+			continue
+		}
+		if next.IsZero() || e.fireAt.Before(next) {
+			next = e.fireAt
+		}
+	}
+	return next
+}
+
+func (s *Scheduler) clock() Clock {
+	if s.Clock == nil {
+		return realClock{}
+	}
+	return s.Clock
+}
+
+// plan moves the entry to its next run after t
+func (e *entry) plan(t time.Time) {
+	e.planned = e.job.Schedule.Next(t)
+	e.fireAt = e.planned
+	if !e.planned.IsZero() && e.job.Jitter > 0 {
+		e.fireAt = e.planned.Add(rand.N(e.job.Jitter))
+	}
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"conc/panics"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) // a monday
+
+// run runs s until the test ends, s.Clock is a ManualClock set before
+// the jobs were added (Add plans the first run with it)
+func run(t *testing.T, s *Scheduler) *ManualClock {
+	t.Helper()
+	clock := s.Clock.(*ManualClock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: %v", err)
+		}
+	})
+
+	clock.BlockUntil(1) // asleep until the first run
+	return clock
+}
+
+// tick moves the clock and waits until the scheduler sleeps again,
+// the runs that were due are started then
+func tick(clock *ManualClock, d time.Duration) {
+	clock.Advance(d)
+	clock.BlockUntil(1)
+}
+
+func TestEvery(t *testing.T) {
+	s := Scheduler{Clock: NewManualClock(start)}
+	var runs atomic.Int32
+	err := s.Add(Job{Name: "count", Schedule: Every(time.Minute), Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := run(t, &s)
+
+	tick(clock, 30*time.Second)
+	s.WaitIdle()
+	if n := runs.Load(); n != 0 {
+		t.Fatalf("after 30s: %d runs, want 0", n)
+	}
+
+	for i := 1; i <= 5; i++ {
+		tick(clock, time.Minute)
+		s.WaitIdle()
+		if n := runs.Load(); n != int32(i) {
+			t.Fatalf("after %d minutes: %d runs, want %d", i, n, i)
+		}
+	}
+
+	// asleep for ten intervals: one run, not ten
+	tick(clock, 10*time.Minute)
+	s.WaitIdle()
+	if n := runs.Load(); n != 6 {
+		t.Fatalf("after a long sleep: %d runs, want 6", n)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	date := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", date(2026, 10, 19, 10, 7), date(2026, 10, 19, 10, 15)},
+		{"*/15 * * * *", date(2026, 10, 19, 10, 15), date(2026, 10, 19, 10, 30)}, // strictly after
+		{"0 9-17 * * *", date(2026, 10, 19, 17, 30), date(2026, 10, 20, 9, 0)},
+		{"@hourly", date(2026, 10, 19, 23, 59), date(2026, 10, 20, 0, 0)},
+
+		// month and year rollover
+		{"0 0 1 * *", date(2026, 1, 31, 12, 0), date(2026, 2, 1, 0, 0)},
+		{"30 9 31 * *", date(2026, 1, 31, 10, 0), date(2026, 3, 31, 9, 30)}, // february has no 31st
+		{"0 0 1 * *", date(2026, 12, 15, 0, 0), date(2027, 1, 1, 0, 0)},
+		{"0 0 29 2 *", date(2026, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"0 0 30 2 *", date(2026, 1, 1, 0, 0), time.Time{}}, // never
+
+		// day of week, 7 is sunday too
+		{"0 12 * * 1", date(2026, 10, 19, 13, 0), date(2026, 10, 26, 12, 0)},
+		{"0 0 * * 7", date(2026, 10, 19, 0, 0), date(2026, 10, 25, 0, 0)},
+
+		// both days restricted: the 13th or a friday
+		{"0 0 13 * 5", date(2026, 10, 1, 0, 0), date(2026, 10, 2, 0, 0)},
+		{"0 0 13 * 5", date(2026, 10, 10, 0, 0), date(2026, 10, 13, 0, 0)},
+		// a field starting with * makes it "and": an odd day that is a friday
+		{"0 0 */2 * 5", date(2026, 10, 1, 0, 0), date(2026, 10, 9, 0, 0)},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "@every -1s", "@every soon"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) gave no error", expr)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		policy  OverlapPolicy
+		started int // runs that start while the first one blocks
+		runs    int32
+	}{
+		{Skip, 1, 1},
+		{Queue, 1, 2}, // three runs due, one queued
+		{Concurrent, 3, 3},
+	}
+	for _, tt := range tests {
+		s := Scheduler{Clock: NewManualClock(start)}
+		release := make(chan struct{})
+		started := make(chan struct{}, 10)
+		var runs, active, maxActive atomic.Int32
+
+		err := s.Add(Job{Name: "slow", Schedule: Every(time.Minute), Overlap: tt.policy, Run: func(ctx context.Context) error {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for m := maxActive.Load(); n > m && !maxActive.CompareAndSwap(m, n); m = maxActive.Load() {
+			}
+			started <- struct{}{}
+			<-release
+			runs.Add(1)
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock := run(t, &s)
+
+		for range 3 {
+			tick(clock, time.Minute)
+		}
+		for range tt.started {
+			<-started
+		}
+		close(release)
+		s.WaitIdle()
+
+		if n := runs.Load(); n != tt.runs {
+			t.Errorf("policy %d: %d runs, want %d", tt.policy, n, tt.runs)
+		}
+		if want := int32(tt.started); maxActive.Load() != want {
+			t.Errorf("policy %d: %d runs at once, want %d", tt.policy, maxActive.Load(), want)
+		}
+	}
+}
+
+func TestPanicIsReported(t *testing.T) {
+	var mu sync.Mutex
+	var got error
+	s := Scheduler{Clock: NewManualClock(start), OnError: func(job string, err error) {
+		mu.Lock()
+		got = err
+		mu.Unlock()
+	}}
+	s.Add(Job{Name: "broken", Schedule: Every(time.Minute), Run: func(ctx context.Context) error {
+		panic("boom")
+	}})
+	clock := run(t, &s)
+
+	tick(clock, time.Minute)
+	s.WaitIdle()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var p *panics.PanicError
+	if !errors.As(got, &p) || p.Value != "boom" {
+		t.Fatalf("OnError got %v, want the panic", got)
+	}
+}
+
+func TestAddRejects(t *testing.T) {
+	var s Scheduler
+	run := func(ctx context.Context) error { return nil }
+
+	if err := s.Add(Job{Name: "x", Run: run}); !errors.Is(err, ErrNoSchedule) {
+		t.Errorf("no schedule: %v", err)
+	}
+	if err := s.Add(Job{Schedule: Every(time.Second), Run: run}); !errors.Is(err, ErrNoName) {
+		t.Errorf("no name: %v", err)
+	}
+	if err := s.Add(Job{Name: "x", Schedule: Every(time.Second), Run: run}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(Job{Name: "x", Schedule: Every(time.Second), Run: run}); err == nil {
+		t.Error("duplicate name was accepted")
+	}
+}
@@ -0,0 +1,117 @@
+// Package scope is structured concurrency: goroutines belong to a scope and
+// the scope doesn't end before all of them did
+//
+// With a bare go statement a goroutine can outlive the function that started
+// it, nobody waits for it and its panic kills the program. Run gives its body
+// a *Scope, every goroutine is started with Scope.Go, and Run returns only
+// after every one of them returned. There is no other way to get a Scope, so
+// a goroutine can't escape:
+//
+//	err := scope.Run(ctx, func(s *scope.Scope) error {
+//		s.Go(func(ctx context.Context) error { return fetch(ctx, "a") })
+//		s.Go(func(ctx context.Context) error { return fetch(ctx, "b") })
+//		return nil
+//	}) // both fetches are done here
+package scope
+
+import (
+	"conc/panics"
+	"context"
+	"sync"
+)
+
+// Scope owns goroutines, it exists only during Run
+type Scope struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	idle   *sync.Cond // signaled when active drops to 0
+	active int
+	closed bool // Run is returning, no more Go
+	err    error
+}
+
+// Run calls body with a new scope and waits for every goroutine started in
+// it. The first error or panic (a *panics.PanicError) of the body or of any
+// goroutine cancels the scope's context and is returned.
+func Run(ctx context.Context, body func(s *Scope) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(context.Canceled)
+
+	s := &Scope{ctx: ctx, cancel: cancel}
+	s.idle = sync.NewCond(&s.mu)
+
+	if err := panics.Call(func() error { return body(s) }); err != nil {
+		s.fail(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.active > 0 {
+		s.idle.Wait()
+	}
+	s.closed = true
+	return s.err
+}
+
+// Context is canceled when the scope fails, is canceled or ends
+func (s *Scope) Context() context.Context {
+	return s.ctx
+}
+
+// Go starts fn in a goroutine of the scope, fn gets the scope's context.
+// fn may call Go too, but calling Go after Run returned panics: the
+// goroutine would have nobody to wait for it.
+func (s *Scope) Go(fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		panic("scope: Go after the scope ended")
+	}
+	s.active++
+	s.mu.Unlock()
+
+	go func() {
+		defer s.done()
+		if err := panics.Call(func() error { return fn(s.ctx) }); err != nil {
+			s.fail(err)
+		}
+	}()
+}
+
+// Wait waits for the goroutines started so far and returns the first error,
+// the body may start more after it. Don't call it from a goroutine of the
+// scope, it would wait for itself.
+func (s *Scope) Wait() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.active > 0 {
+		s.idle.Wait()
+	}
+	return s.err
+}
+
+// Cancel cancels the context of every goroutine in the scope,
+// Run still waits for them to return
+func (s *Scope) Cancel() {
+	s.cancel(context.Canceled)
+}
+
+func (s *Scope) done() {
+	s.mu.Lock()
+	s.active--
+	if s.active == 0 {
+		s.idle.Broadcast()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Scope) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.cancel(err)
+	}
+}
@@ -0,0 +1,129 @@
+// Package semaphore limits how much of a resource goroutines can hold at the same time,
+// every goroutine asks for a weight instead of a single slot
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Weighted is a semaphore with a total size, create it with NewWeighted
+//
+// Waiters are served in the order they came (FIFO), so a big Acquire is not
+// starved by a stream of small ones.
+type Weighted struct {
+	size    int64
+	cur     int64 // how much is held right now
+	mu      sync.Mutex
+	waiters list.List // of waiter
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{} // closed when the weight was given to the waiter
+}
+
+// NewWeighted creates a semaphore with the given total weight
+func NewWeighted(n int64) *Weighted {
+	return &Weighted{size: n}
+}
+
+// Acquire takes n, blocking until it's available or ctx is done
+// on failure it returns ctx.Err() and holds nothing
+func (s *Weighted) Acquire(ctx context.Context, n int64) error {
+	done := ctx.Done()
+
+	s.mu.Lock()
+	select {
+	case <-done:
+		// don't take the weight when the caller already gave up
+		s.mu.Unlock()
+		return ctx.Err()
+	default:
+	}
+
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	if n > s.size {
+		// it will never fit, just wait for ctx
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(waiter{n: n, ready: ready})
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+
+	case <-done:
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// we got it right when ctx was canceled, give it back
+			s.cur -= n
+			s.notifyWaiters()
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// the first waiter could be blocking smaller ones behind it
+			if isFront && s.size > s.cur {
+				s.notifyWaiters()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes n without blocking, it reports whether it worked
+func (s *Weighted) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release gives back n, releasing more than was acquired panics
+func (s *Weighted) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cur -= n
+	if s.cur < 0 {
+		panic("semaphore: released more than held")
+	}
+	s.notifyWaiters()
+}
+
+// notifyWaiters wakes the waiters from the front while they fit, s.mu is held
+func (s *Weighted) notifyWaiters() {
+	for {
+		next := s.waiters.Front()
+		if next == nil {
+			return
+		}
+
+		w := next.Value.(waiter)
+		if s.size-s.cur < w.n {
+			// keep the order, smaller waiters behind can't jump the queue
+			return
+		}
+
+		s.cur += w.n
+		s.waiters.Remove(next)
+		close(w.ready)
+	}
+}
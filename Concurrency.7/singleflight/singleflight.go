@@ -0,0 +1,140 @@
+// Package singleflight coalesces duplicate calls: when many goroutines ask
+// for the same key at the same time, the work is done once and everybody
+// gets the same result
+package singleflight
+
+import (
+	"conc/panics"
+	"context"
+	"sync"
+	"time"
+)
+
+// Group is safe for concurrent use, the zero value is ready to use
+type Group[K comparable, V any] struct {
+	// TTL keeps a successful result for a short time, so callers that come
+	// right after the call finished get it too. Zero means results are not kept
+	TTL time.Duration
+
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+type call[V any] struct {
+	done chan struct{} // closed when fn returned
+
+	val V
+	err error
+
+	finished bool
+	expires  time.Time
+
+	waiters int // callers still waiting, when it drops to zero the work is canceled
+	callers int // everybody who got (or waits for) this result
+	cancel  context.CancelFunc
+}
+
+// Do runs fn for the key, unless a call for the same key is already in flight
+// (or its result is still kept), then it waits for that one instead
+//
+// fn gets its own context, it's not canceled when one caller gives up. Only
+// when every caller left the work is canceled. A caller whose ctx is done
+// returns ctx.Err() right away.
+//
+// shared reports whether the result was given to more than one caller.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (v V, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+
+	c, ok := g.calls[key]
+	if ok && c.finished {
+		if time.Now().Before(c.expires) {
+			c.callers++
+			g.mu.Unlock()
+			return c.val, true, c.err
+		}
+		delete(g.calls, key) // kept too long
+		ok = false
+	}
+
+	if ok {
+		c.waiters++
+		c.callers++
+	} else {
+		// WithoutCancel keeps the values of the first caller but not its cancellation
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{
+			done:    make(chan struct{}),
+			waiters: 1,
+			callers: 1,
+			cancel:  cancel,
+		}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		g.mu.Lock()
+		c.waiters--
+		shared = c.callers > 1
+		g.mu.Unlock()
+		return c.val, shared, c.err
+
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		c.callers--
+		if c.waiters == 0 && !c.finished {
+			// nobody wants the result anymore
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// Forget drops the key, the next Do starts a new call even if one is in flight
+func (g *Group[K, V]) Forget(key K) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+}
+
+func (g *Group[K, V]) run(ctx context.Context, key K, c *call[V], fn func(ctx context.Context) (V, error)) {
+	defer c.cancel()
+
+	// a panic must not leave the waiting callers hanging forever
+	c.err = panics.Call(func() error {
+		var err error
+		c.val, err = fn(ctx)
+		return err
+	})
+
+	g.mu.Lock()
+	c.finished = true
+	if g.calls[key] == c {
+		if c.err == nil && g.TTL > 0 {
+			c.expires = time.Now().Add(g.TTL)
+			time.AfterFunc(g.TTL, func() {
+				g.mu.Lock()
+				if g.calls[key] == c {
+					delete(g.calls, key)
+				}
+				g.mu.Unlock()
+			})
+		} else {
+			delete(g.calls, key)
+		}
+	}
+	g.mu.Unlock()
+
+	close(c.done)
+}
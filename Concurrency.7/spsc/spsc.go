@@ -0,0 +1,155 @@
+// Package spsc is a bounded queue for exactly one producer and one consumer
+//
+// A channel is safe for any number of senders and receivers, and it pays for
+// that with a lock on every operation. When only one goroutine pushes and
+// only one pops, two atomic counters are enough: the producer is the only
+// one writing tail, the consumer is the only one writing head.
+//
+// Using a Ring from more than one producer or more than one consumer is a
+// data race, use a channel there.
+package spsc
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Push after Close
+var ErrClosed = errors.New("spsc: ring is closed")
+
+// cacheLine is 64 bytes on amd64 and most arm64 cpus. Fields written by
+// different goroutines must not share a line, otherwise every write of
+// the producer invalidates the line the consumer is reading (false sharing).
+const cacheLine = 64
+
+type pad [cacheLine]byte
+
+// Ring is the queue, create it with New
+type Ring[T any] struct {
+	_    pad
+	head atomic.Uint64 // next slot to read, only the consumer writes it
+	_    pad
+	tail atomic.Uint64 // next slot to write, only the producer writes it
+	_    pad
+
+	// each side remembers the last index of the other side it saw and loads
+	// the atomic again only when the ring looks full (or empty)
+	headCache uint64 // producer only
+	_         pad
+	tailCache uint64 // consumer only
+	_         pad
+
+	closed atomic.Bool
+	mask   uint64
+	buf    []T
+}
+
+// New creates a ring for at least capacity items, the capacity is rounded
+// up to a power of two so the index is a mask instead of a division
+func New[T any](capacity int) *Ring[T] {
+	if capacity < 1 {
+		panic("spsc: capacity must be positive")
+	}
+	size := uint64(1)
+	for size < uint64(capacity) {
+		size <<= 1
+	}
+	return &Ring[T]{mask: size - 1, buf: make([]T, size)}
+}
+
+// Cap is the real capacity
+func (r *Ring[T]) Cap() int {
+	return len(r.buf)
+}
+
+// Len is the number of items right now, only a hint while the other side works
+func (r *Ring[T]) Len() int {
+	return int(r.tail.Load() - r.head.Load())
+}
+
+// TryPush adds v if there is room, producer only
+func (r *Ring[T]) TryPush(v T) bool {
+	if r.closed.Load() {
+		return false
+	}
+
+	tail := r.tail.Load() // we are the only writer, no race with ourselves
+	if tail-r.headCache == uint64(len(r.buf)) {
+		r.headCache = r.head.Load()
+		if tail-r.headCache == uint64(len(r.buf)) {
+			return false // really full
+		}
+	}
+
+	r.buf[tail&r.mask] = v
+	r.tail.Store(tail + 1) // publishes the slot: the consumer reads it after loading tail
+	return true
+}
+
+// Push waits for room, it returns ErrClosed if the ring is closed
+// (the consumer may close it to tell the producer to stop)
+func (r *Ring[T]) Push(v T) error {
+	for i := 0; ; i++ {
+		if r.TryPush(v) {
+			return nil
+		}
+		if r.closed.Load() {
+			return ErrClosed
+		}
+		backoff(i)
+	}
+}
+
+// TryPop takes the oldest item if there is one, consumer only
+func (r *Ring[T]) TryPop() (T, bool) {
+	head := r.head.Load()
+	if head == r.tailCache {
+		r.tailCache = r.tail.Load()
+		if head == r.tailCache {
+			var zero T
+			return zero, false
+		}
+	}
+
+	i := head & r.mask
+	v := r.buf[i]
+	var zero T
+	r.buf[i] = zero // don't keep pointers alive for the garbage collector
+	r.head.Store(head + 1)
+	return v, true
+}
+
+// Pop waits for an item, after Close it returns what is left and then false
+func (r *Ring[T]) Pop() (T, bool) {
+	for i := 0; ; i++ {
+		if v, ok := r.TryPop(); ok {
+			return v, true
+		}
+		if r.closed.Load() {
+			// the producer may have pushed right before it closed
+			return r.TryPop()
+		}
+		backoff(i)
+	}
+}
+
+// Close stops Push, Pop drains the items that are already in the ring
+func (r *Ring[T]) Close() {
+	r.closed.Store(true)
+}
+
+// backoff spins first (the other side is usually a few nanoseconds away),
+// then gives the processor to other goroutines and at last sleeps, so a
+// waiting side doesn't burn a whole cpu
+func backoff(i int) {
+	switch {
+	case i < 32:
+		// spin
+	case i < 128:
+		runtime.Gosched()
+	default:
+		time.Sleep(50 * time.Microsecond)
+	}
+}
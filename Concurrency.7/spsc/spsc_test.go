@@ -0,0 +1,35 @@
+package spsc
+
+import "testing"
+
+// one op is one item from the producer goroutine to the consumer
+//
+//	go test -bench . ./spsc
+const capacity = 1024
+
+func BenchmarkChannel(b *testing.B) {
+	ch := make(chan int, capacity)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	for range ch {
+	}
+}
+
+func BenchmarkRing(b *testing.B) {
+	r := New[int](capacity)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			r.Push(i)
+		}
+		r.Close()
+	}()
+	for {
+		if _, ok := r.Pop(); !ok {
+			break
+		}
+	}
+}
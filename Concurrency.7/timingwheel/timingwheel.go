@@ -0,0 +1,305 @@
+// Package timingwheel runs a huge number of timers with one goroutine and
+// one time.Ticker
+//
+// Every time.AfterFunc goes into the runtime timer heap: adding and removing
+// is O(log n) under a lock, and with 100k pending request timeouts that shows
+// up in profiles. A timing wheel puts a timer into a slot by its expiration
+// tick, adding and stopping is O(1). The price is precision: a timer fires
+// on the first tick after its deadline, never earlier.
+//
+// The wheel is hierarchical, like a clock with hands: level 0 has 64 slots
+// of one tick, level 1 has 64 slots of 64 ticks and so on. A far timer waits
+// in a coarse slot and moves down a level every time that slot comes around.
+package timingwheel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	slotBits  = 6
+	wheelSize = 1 << slotBits // slots per level
+	slotMask  = wheelSize - 1
+	levels    = 6 // 64^6 ticks, two years with a 1ms tick; longer timers just go around again
+)
+
+// Wheel is a set of timers, create it with New and stop it with Stop
+type Wheel struct {
+	tick  time.Duration
+	start time.Time
+
+	mu     sync.Mutex
+	now    uint64 // ticks since start that were processed
+	slots  [levels][wheelSize]list
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Timer is one timer of a Wheel
+type Timer struct {
+	w       *Wheel
+	fn      func()
+	expires uint64 // absolute tick
+
+	// the slot list, nil list means the timer is not pending
+	list       *list
+	prev, next *Timer
+}
+
+type list struct {
+	head *Timer
+}
+
+// New starts a wheel, a timer fires at most one tick late
+func New(tick time.Duration) *Wheel {
+	if tick <= 0 {
+		panic("timingwheel: tick must be positive")
+	}
+	w := &Wheel{
+		tick:  tick,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Stop stops the wheel, pending timers never fire
+func (w *Wheel) Stop() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+}
+
+// AfterFunc calls fn in its own goroutine after d, like time.AfterFunc
+func (w *Wheel) AfterFunc(d time.Duration, fn func()) *Timer {
+	t := &Timer{w: w, fn: fn}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t.expires = w.expiration(d)
+	if !w.closed {
+		w.add(t)
+	}
+	return t
+}
+
+// Stop cancels the timer, it reports false if it already fired or was stopped
+func (t *Timer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+
+	if t.list == nil {
+		return false
+	}
+	t.list.remove(t)
+	return true
+}
+
+// Reset moves the timer to d from now, it reports whether it was pending
+// it also works on a timer that already fired, fn runs again
+func (t *Timer) Reset(d time.Duration) bool {
+	w := t.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pending := t.list != nil
+	if pending {
+		t.list.remove(t)
+	}
+	t.expires = w.expiration(d)
+	if !w.closed {
+		w.add(t)
+	}
+	return pending
+}
+
+// expiration rounds d up to whole ticks, so a timer never fires early.
+// It counts from the wall clock, not from w.now: the run loop may be a
+// few ticks behind and catching up.
+func (w *Wheel) expiration(d time.Duration) uint64 {
+	elapsed := time.Since(w.start) + d
+	ticks := uint64((elapsed + w.tick - 1) / w.tick)
+	return max(ticks, w.now+1)
+}
+
+// add puts t into the slot of its level, w.mu is held
+func (w *Wheel) add(t *Timer) {
+	delta := t.expires - w.now
+	if t.expires <= w.now {
+		delta = 0
+	}
+
+	for level := 0; level < levels; level++ {
+		shift := uint(slotBits * level)
+		if delta < 1<<(shift+slotBits) {
+			w.slots[level][(t.expires>>shift)&slotMask].push(t)
+			return
+		}
+	}
+
+	// further than the whole wheel: park it in the top slot that comes around
+	// last, from there it's added again with the real expiration
+	shift := uint(slotBits * (levels - 1))
+	w.slots[levels-1][((w.now>>shift)+slotMask)&slotMask].push(t)
+}
+
+func (w *Wheel) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			// the ticker drops ticks when we are slow, so catch up to the clock
+			target := uint64(now.Sub(w.start) / w.tick)
+			w.advance(target)
+		}
+	}
+}
+
+// advance processes ticks up to target and starts the timers that expired
+func (w *Wheel) advance(target uint64) {
+	var fire []func()
+
+	w.mu.Lock()
+	for w.now < target {
+		w.now++
+
+		// when level 0 goes around, the next slot of level 1 moves down, and so on
+		if w.now&slotMask == 0 {
+			for level := 1; level < levels; level++ {
+				shift := uint(slotBits * level)
+				idx := (w.now >> shift) & slotMask
+				for _, t := range w.slots[level][idx].takeAll() {
+					w.add(t)
+				}
+				if idx != 0 {
+					break
+				}
+			}
+		}
+
+		for _, t := range w.slots[0][w.now&slotMask].takeAll() {
+			if t.expires > w.now {
+				w.add(t) // parked at the top level, not its time yet
+				continue
+			}
+			fire = append(fire, t.fn)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, fn := range fire {
+		go fn()
+	}
+}
+
+func (l *list) push(t *Timer) {
+	t.list = l
+	t.prev = nil
+	t.next = l.head
+	if l.head != nil {
+		l.head.prev = t
+	}
+	l.head = t
+}
+
+func (l *list) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.list, t.prev, t.next = nil, nil, nil
+}
+
+func (l *list) takeAll() []*Timer {
+	var all []*Timer
+	for t := l.head; t != nil; {
+		next := t.next
+		t.list, t.prev, t.next = nil, nil, nil
+		all = append(all, t)
+		t = next
+	}
+	l.head = nil
+	return all
+}
+
+// WithTimeout is context.WithTimeout on the wheel
+func (w *Wheel) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return w.WithDeadline(parent, time.Now().Add(d))
+}
+
+// WithDeadline is context.WithDeadline on the wheel: the context is done at
+// most one tick after deadline and Err returns context.DeadlineExceeded
+func (w *Wheel) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if cur, ok := parent.Deadline(); ok && cur.Before(deadline) {
+		return context.WithCancel(parent) // the parent is done earlier anyway
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	c := &deadlineCtx{Context: ctx, parent: parent, deadline: deadline}
+
+	t := w.AfterFunc(time.Until(deadline), func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return c, func() {
+		t.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+type deadlineCtx struct {
+	context.Context // canceled with DeadlineExceeded as the cause
+	parent          context.Context
+	deadline        time.Time
+}
+
+func (c *deadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+// Err says DeadlineExceeded like a real deadline context, WithCancelCause
+// alone would say Canceled
+func (c *deadlineCtx) Err() error {
+	err := c.Context.Err()
+	if err != nil && context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Value skips the cancelCtx inside c. context.WithCancel(c) looks for it
+// with Value and would copy its error (Canceled) into the child, without it
+// the child waits with AfterFunc below and asks Err, so it says
+// DeadlineExceeded too.
+func (c *deadlineCtx) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+// AfterFunc is what context.AfterFunc and children of c use to wait for c,
+// without it they would need a goroutine each
+func (c *deadlineCtx) AfterFunc(f func()) (stop func() bool) {
+	return context.AfterFunc(c.Context, f)
+}
@@ -0,0 +1,41 @@
+package timingwheel
+
+import (
+	"testing"
+	"time"
+)
+
+// one op: start a timer and stop it before it fires, the way a request
+// timeout is used, while 100k other timers are pending
+//
+//	go test -bench . ./timingwheel
+const pending = 100_000
+
+func noop() {}
+
+func BenchmarkRuntime(b *testing.B) {
+	timers := make([]*time.Timer, pending)
+	for i := range timers {
+		timers[i] = time.AfterFunc(time.Hour+time.Duration(i)*time.Millisecond, noop)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		time.AfterFunc(time.Minute, noop).Stop()
+	}
+	b.StopTimer()
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+func BenchmarkWheel(b *testing.B) {
+	w := New(time.Millisecond)
+	defer w.Stop()
+	for i := 0; i < pending; i++ {
+		w.AfterFunc(time.Hour+time.Duration(i)*time.Millisecond, noop)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.AfterFunc(time.Minute, noop).Stop()
+	}
+}
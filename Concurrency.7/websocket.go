@@ -0,0 +1,255 @@
+package main
+
+import (
+	"conc/semaphore"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+/********
+### WebSocket ###
+*********/
+
+/*
+One websocket connection carries many tasks. The client sends
+
+	{"type":"submit","id":"my-1","task":"build"}
+
+and gets the result when it's ready, tagged with its own id:
+
+	{"type":"result","id":"my-1","result":"Processed: build"}
+
+Results come back in the order tasks finish, not the order they were sent.
+
+The server sends {"type":"ping"} every ws_ping_interval and the client must
+send something back ({"type":"pong"} is fine) in two intervals, otherwise we
+think the connection is dead. We don't use websocket ping frames for this:
+golang.org/x/net/websocket answers pings itself but throws pongs away.
+*/
+
+// wsMessage is every message in both directions
+type wsMessage struct {
+	Type   string `json:"type"`             // submit, result, error, ping, pong
+	ID     string `json:"id,omitempty"`     // the id the client gave to the task
+	Task   string `json:"task,omitempty"`   // submit
+	Result string `json:"result,omitempty"` // result
+	Error  string `json:"error,omitempty"`  // error
+}
+
+// websocket close codes (RFC 6455)
+const (
+	wsCloseNormal          = 1000
+	wsCloseGoingAway       = 1001 // the server is stopping
+	wsCloseUnsupportedData = 1003 // not json
+	wsClosePolicyViolation = 1008 // no pong in time
+	wsCloseTooBig          = 1009
+)
+
+const wsMaxMessage = 64 << 10
+
+// HandleTaskSocket serves one connection
+// GET /ws/tasks
+func (r *RealServ) HandleTaskSocket(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = wsMaxMessage
+
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	cfg := r.config.Load() // a connection keeps the settings it started with
+	pongWait := 2 * cfg.WSPingInterval.D()
+	inFlight := semaphore.NewWeighted(cfg.WSMaxInFlight)
+
+	out := make(chan wsMessage, cfg.WSMaxInFlight+4) // only the writer goroutine writes to ws
+	flush := make(chan struct{})                     // closed when the writer should send what's left and stop
+	writerDone := make(chan struct{})
+
+	go func() {
+		defer close(writerDone)
+		r.writeSocket(ws, out, flush, cfg.WSPingInterval.D())
+	}()
+
+	// send never blocks forever, after the writer stopped messages are dropped
+	send := func(m wsMessage) {
+		select {
+		case out <- m:
+		case <-writerDone:
+		}
+	}
+
+	var (
+		tasks     sync.WaitGroup
+		mu        sync.Mutex
+		closing   bool // no new tasks
+		closed    bool // the close frame was sent, the reader must stop
+		closeOnce sync.Once
+	)
+
+	// closeWith cancels the tasks of this connection, waits until their
+	// results are written and says goodbye with the close code
+	closeWith := func(code int, reason string) {
+		closeOnce.Do(func() {
+			mu.Lock()
+			closing = true // no new tasks.Add after this
+			mu.Unlock()
+
+			cancel()
+			tasks.Wait()
+			close(flush)
+			<-writerDone
+
+			// one close frame per side (RFC 6455): ws.Close() would send a
+			// second one with 1000, so the reader is stopped with a deadline
+			// instead and websocket.Handler closes the connection when we return
+			writeClose(ws, code, reason)
+			mu.Lock()
+			closed = true
+			ws.SetReadDeadline(time.Now())
+			mu.Unlock()
+		})
+	}
+
+	// the server is stopping
+	go func() {
+		select {
+		case <-r.doneCh:
+			closeWith(wsCloseGoingAway, "server is stopping")
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(pongWait)) // under mu, or it could undo the deadline of closeWith
+		mu.Unlock()
+
+		var msg wsMessage
+		err := websocket.JSON.Receive(ws, &msg)
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			switch {
+			case errors.Is(err, io.EOF):
+				closeWith(wsCloseNormal, "") // the client closed the connection
+			case errors.Is(err, websocket.ErrFrameTooLarge):
+				closeWith(wsCloseTooBig, "message too big")
+			case isTimeout(err):
+				closeWith(wsClosePolicyViolation, "no pong") // a no-op when closeWith stopped us
+			case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+				closeWith(wsCloseUnsupportedData, "messages must be json")
+			default:
+				closeWith(wsCloseGoingAway, "") // the network is gone
+			}
+			return
+		}
+
+		switch msg.Type {
+		case "pong":
+			// nothing to do, reading it already moved the deadline
+
+		case "ping":
+			send(wsMessage{Type: "pong"})
+
+		case "submit":
+			if msg.ID == "" || msg.Task == "" {
+				send(wsMessage{Type: "error", ID: msg.ID, Error: "submit needs id and task"})
+				continue
+			}
+			if !inFlight.TryAcquire(1) {
+				// don't block here, we still have to read pongs
+				send(wsMessage{Type: "error", ID: msg.ID, Error: "too many tasks in flight"})
+				continue
+			}
+
+			mu.Lock()
+			if closing {
+				mu.Unlock()
+				inFlight.Release(1)
+				continue
+			}
+			tasks.Add(1)
+			mu.Unlock()
+
+			go func(m wsMessage) {
+				defer tasks.Done()
+				defer inFlight.Release(1)
+
+				result, err := r.process(ctx, m.Task)
+				if err != nil {
+					send(wsMessage{Type: "error", ID: m.ID, Error: err.Error()})
+					return
+				}
+				send(wsMessage{Type: "result", ID: m.ID, Result: result})
+			}(msg)
+
+		default:
+			send(wsMessage{Type: "error", ID: msg.ID, Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// writeSocket sends messages and pings until flush is closed
+func (r *RealServ) writeSocket(ws *websocket.Conn, out <-chan wsMessage, flush <-chan struct{}, pingInterval time.Duration) {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	write := func(m wsMessage) bool {
+		ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return websocket.JSON.Send(ws, m) == nil
+	}
+
+	for {
+		select {
+		case m := <-out:
+			if !write(m) {
+				return
+			}
+
+		case <-ping.C:
+			if !write(wsMessage{Type: "ping"}) {
+				return
+			}
+
+		case <-flush:
+			// the tasks are done, send their last results
+			for {
+				select {
+				case m := <-out:
+					if !write(m) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeClose sends a close frame with a status code, x/net/websocket only
+// has Close() which always says 1000
+func writeClose(ws *websocket.Conn, code int, reason string) error {
+	msg := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(msg, uint16(code))
+	copy(msg[2:], reason)
+
+	ws.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.PayloadType = websocket.CloseFrame
+	_, err := ws.Write(msg)
+	return err
+}
+
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
@@ -0,0 +1,234 @@
+// Package wordcount counts words, lines and bytes of every file in a
+// directory tree with a fixed number of goroutines
+//
+// One goroutine walks the tree and sends paths into a channel, the workers
+// read paths and send results into another channel, the caller reads
+// results while the others are still working. Canceling the context stops
+// all of them, even in the middle of a big file.
+package wordcount
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// FileResult is the count of one file, or why it has none
+type FileResult struct {
+	Path    string
+	Lines   int64
+	Words   int64
+	Bytes   int64
+	Freq    map[string]int // lower case word -> count
+	Skipped bool           // a binary file
+	Err     error
+}
+
+// Count walks root and counts every regular file with workers goroutines.
+// The channel is closed when everything is counted or ctx is canceled.
+// Directories starting with a dot (.git) are not walked.
+func Count(ctx context.Context, root string, workers int) <-chan FileResult {
+	workers = max(workers, 1)
+
+	paths := make(chan string, workers)
+	results := make(chan FileResult, workers)
+
+	// select picks at random when both cases are ready, so after a cancel
+	// we look at ctx first or a few more results would slip through
+	send := func(r FileResult) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case results <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(paths) // the workers stop after the last path
+
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				if !send(FileResult{Path: path, Err: err}) {
+					return ctx.Err()
+				}
+				return nil // go on with the rest of the tree
+			}
+			if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			select {
+			case paths <- path:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if !send(countFile(ctx, path)) {
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func countFile(ctx context.Context, path string) FileResult {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileResult{Path: path, Err: err}
+	}
+	defer f.Close()
+
+	r, err := CountReader(ctx, f)
+	r.Path = path
+	if err != nil {
+		r.Err = err
+	}
+	return r
+}
+
+// checkEvery is how many bytes we read between looks at ctx
+const checkEvery = 64 << 10
+
+// CountReader counts r, a word is a run of letters and digits ("don't" is
+// two words, "go1" is one)
+func CountReader(ctx context.Context, r io.Reader) (FileResult, error) {
+	res := FileResult{Freq: make(map[string]int)}
+	br := bufio.NewReader(r)
+
+	// a file with NUL bytes in the beginning is binary, like git decides it
+	if head, _ := br.Peek(8000); bytes.IndexByte(head, 0) >= 0 {
+		res.Skipped = true
+		return res, nil
+	}
+
+	var word strings.Builder
+	endWord := func() {
+		if word.Len() > 0 {
+			res.Words++
+			res.Freq[word.String()]++
+			word.Reset()
+		}
+	}
+
+	var sinceCheck int
+	for {
+		c, size, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		res.Bytes += int64(size)
+		if c == '\n' {
+			res.Lines++
+		}
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			word.WriteRune(unicode.ToLower(c))
+		} else {
+			endWord()
+		}
+
+		if sinceCheck += size; sinceCheck >= checkEvery {
+			sinceCheck = 0
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+		}
+	}
+	endWord()
+	return res, nil
+}
+
+// Totals is the sum of many FileResults
+type Totals struct {
+	Files   int
+	Skipped int
+	Errors  int
+	Lines   int64
+	Words   int64
+	Bytes   int64
+	Freq    map[string]int
+}
+
+// Add merges one result, only the goroutine that reads the results calls it
+func (t *Totals) Add(r FileResult) {
+	switch {
+	case r.Err != nil:
+		t.Errors++
+		return
+	case r.Skipped:
+		t.Skipped++
+		return
+	}
+
+	if t.Freq == nil {
+		t.Freq = make(map[string]int)
+	}
+	t.Files++
+	t.Lines += r.Lines
+	t.Words += r.Words
+	t.Bytes += r.Bytes
+	for w, n := range r.Freq {
+		t.Freq[w] += n
+	}
+}
+
+// WordCount is one line of Top
+type WordCount struct {
+	Word  string
+	Count int
+}
+
+// Top returns the n most frequent words, equal counts in alphabetical order
+// (none for n <= 0)
+func (t *Totals) Top(n int) []WordCount {
+	n = max(n, 0)
+	all := make([]WordCount, 0, len(t.Freq))
+	for w, c := range t.Freq {
+		all = append(all, WordCount{w, c})
+	}
+	slices.SortFunc(all, func(a, b WordCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Word, b.Word)
+	})
+	return all[:min(n, len(all))]
+}
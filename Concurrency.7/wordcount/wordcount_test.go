@@ -0,0 +1,188 @@
+package wordcount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree creates the files under a new temp dir, names are slash separated
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func collect(ch <-chan FileResult) map[string]FileResult {
+	got := map[string]FileResult{}
+	for r := range ch {
+		got[r.Path] = r
+	}
+	return got
+}
+
+func TestCount(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":         "Go is fun\ngo GO go\n",
+		"sub/b.md":      "don't panic\n",
+		"sub/deep/c":    "no newline at the end",
+		"empty":         "",
+		"bin/tool":      "\x7fELF\x00\x00\x01 some strings inside",
+		".git/HEAD":     "ref: refs/heads/main\n",
+		"sub/.hidden/x": "hidden dirs are skipped\n",
+	})
+
+	for _, workers := range []int{0, 1, 4} {
+		got := collect(Count(context.Background(), root, workers))
+		if len(got) != 5 {
+			t.Fatalf("%d workers: %d results, want 5: %v", workers, len(got), got)
+		}
+
+		var total Totals
+		for path, r := range got {
+			if r.Err != nil {
+				t.Fatalf("%s: %v", path, r.Err)
+			}
+			total.Add(r)
+		}
+
+		if r := got[filepath.Join(root, "bin", "tool")]; !r.Skipped || r.Words != 0 {
+			t.Errorf("the binary was counted: %+v", r)
+		}
+		if r := got[filepath.Join(root, "a.txt")]; r.Lines != 2 || r.Words != 6 || r.Bytes != 19 || r.Freq["go"] != 4 {
+			t.Errorf("a.txt: %+v", r)
+		}
+
+		want := Totals{Files: 4, Skipped: 1, Lines: 3, Words: 14, Bytes: 52}
+		if total.Files != want.Files || total.Skipped != want.Skipped || total.Lines != want.Lines ||
+			total.Words != want.Words || total.Bytes != want.Bytes {
+			t.Errorf("%d workers: totals %+v, want %+v", workers, total, want)
+		}
+	}
+}
+
+func TestCountMissingRoot(t *testing.T) {
+	got := collect(Count(context.Background(), filepath.Join(t.TempDir(), "nope"), 2))
+	if len(got) != 1 {
+		t.Fatalf("%d results, want one error", len(got))
+	}
+	for _, r := range got {
+		if !errors.Is(r.Err, os.ErrNotExist) {
+			t.Fatalf("err %v, want ErrNotExist", r.Err)
+		}
+	}
+}
+
+func TestCountReader(t *testing.T) {
+	tests := []struct {
+		text  string
+		words int64
+		freq  map[string]int
+	}{
+		{"", 0, nil},
+		{"don't", 2, map[string]int{"don": 1, "t": 1}},
+		{"go1 Go1 GO1", 3, map[string]int{"go1": 3}},
+		{"Größe größe", 2, map[string]int{"größe": 2}},
+		{"  a\tb\n\nc  ", 3, map[string]int{"a": 1, "b": 1, "c": 1}},
+	}
+	for _, tt := range tests {
+		r, err := CountReader(context.Background(), strings.NewReader(tt.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Words != tt.words || r.Bytes != int64(len(tt.text)) {
+			t.Errorf("%q: %d words %d bytes, want %d and %d", tt.text, r.Words, r.Bytes, tt.words, len(tt.text))
+		}
+		for w, n := range tt.freq {
+			if r.Freq[w] != n {
+				t.Errorf("%q: %q %d times, want %d", tt.text, w, r.Freq[w], n)
+			}
+		}
+	}
+}
+
+// endless is a reader that never ends, only ctx can stop CountReader
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = "word "[i%5]
+	}
+	return len(p), nil
+}
+
+func TestCountReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := CountReader(ctx, endless{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestCountCancel(t *testing.T) {
+	files := map[string]string{}
+	for i := range 1000 {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = "some words here\n"
+	}
+	root := writeTree(t, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := Count(ctx, root, 4)
+
+	<-results // the first one, then we don't want more
+	cancel()
+
+	// what is in the buffer may still come, then the channel is closed
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range results {
+			n++
+		}
+		done <- n
+	}()
+	select {
+	case n := <-done:
+		if n > 100 {
+			t.Errorf("%d results after cancel, the workers didn't stop", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel was not closed after cancel")
+	}
+}
+
+func TestTop(t *testing.T) {
+	total := Totals{Freq: map[string]int{"b": 2, "a": 2, "c": 5, "d": 1}}
+	got := total.Top(3)
+	want := []WordCount{{"c", 5}, {"a", 2}, {"b", 2}}
+	if len(got) != len(want) {
+		t.Fatalf("Top(3) = %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Top(3) = %v, want %v", got, want)
+		}
+	}
+	if n := len(total.Top(10)); n != 4 {
+		t.Errorf("Top(10) has %d words, want 4", n)
+	}
+	if n := len(total.Top(-1)); n != 0 {
+		t.Errorf("Top(-1) has %d words, want 0", n)
+	}
+}
@@ -247,6 +247,7 @@ func customSort(data []int, less func(i, j int) bool) {
 	*/
 	sort.Slice(data, less) // we import Slice from the sort package
 	//sorts a slice of data using the less function
+	// for a version that sorts with several goroutines see parallel.SortFunc in Concurrency.7
 }
 
 func MakeSortExample() {
@@ -40,6 +40,7 @@ func NewCustomMap[K comparable, V any]() *Custom[K, V] {
 var a = []int{1, 2, 3}
 var b = []byte{1, 2, 3}
 
+// one goroutine adds everything, parallel.Sum in Concurrency.7 splits the slice between several
 func Sum[Y int | byte](input []Y) Y {
 	var result Y
 